@@ -45,6 +45,28 @@ import EmptyConfirmationDialog from "./components/EmptyConfirmationDialog";
 
 const ddClient = createDockerDesktopClient();
 
+// ActionProgress is the progress of an action running for a volume, as returned by the /progress endpoint.
+interface ActionProgress {
+  action: string;
+  bytes: number;
+  totalBytes: number;
+  files: number;
+  // eta is the estimated number of seconds left, or -1 if it is unknown.
+  eta: number;
+}
+
+const formatDuration = (seconds: number) => {
+  if (seconds < 60) {
+    return `${seconds} s`;
+  }
+  if (seconds < 3600) {
+    return `${Math.round(seconds / 60)} min`;
+  }
+  const hours = Math.floor(seconds / 3600);
+  const minutes = Math.round((seconds % 3600) / 60);
+  return `${hours} h ${minutes} min`;
+};
+
 function CustomToolbar() {
   return (
     <GridToolbarContainer>
@@ -80,7 +102,9 @@ export function App() {
   const [openEmptyConfirmationDialog, setOpenEmptyConfirmationDialog] =
     React.useState<boolean>(false);
 
-  const [actionsInProgress, setActionsInProgress] = React.useState({});
+  const [actionsInProgress, setActionsInProgress] = React.useState<
+    Record<string, ActionProgress>
+  >({});
 
   const [recalculateVolumeSize, setRecalculateVolumeSize] =
     React.useState<string>(null);
@@ -160,7 +184,16 @@ export function App() {
       flex: 1,
       getActions: (params) => {
         if (params.row.volumeName in actionsInProgress) {
-          const action = actionsInProgress[params.row.volumeName];
+          const { action, bytes, totalBytes, eta } =
+            actionsInProgress[params.row.volumeName];
+          let details = "";
+          if (totalBytes > 0) {
+            const percent = Math.floor((bytes * 100) / totalBytes);
+            details += ` ${Math.min(100, percent)}%`;
+          }
+          if (eta > 0) {
+            details += ` (${formatDuration(eta)} left)`;
+          }
           return [
             <GridActionsCellItem
               className="circular-progress"
@@ -170,7 +203,7 @@ export function App() {
                   <CircularProgress size={20} />
                   <Typography ml={2}>
                     {action.charAt(0).toUpperCase() + action.slice(1)} in
-                    progress...
+                    progress...{details}
                   </Typography>
                 </>
               }
@@ -329,7 +362,7 @@ export function App() {
     ddClient.extension.vm.service
       .get("/progress")
       .then((result: unknown) => {
-        setActionsInProgress(result);
+        setActionsInProgress(result as Record<string, ActionProgress>);
       })
       .catch((error) => {
         console.error(error);
@@ -340,6 +373,16 @@ export function App() {
     getActionsInProgress();
   }, []);
 
+  // Refresh the progress of the actions every second while there is at least one in progress
+  useEffect(() => {
+    if (Object.keys(actionsInProgress).length === 0) {
+      return;
+    }
+    const timer = setTimeout(getActionsInProgress, 1000);
+    return () => clearTimeout(timer);
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [actionsInProgress]);
+
   useEffect(() => {
     const extensionContainersEvents = async () => {
       console.log("listening to extension's container events...");
@@ -164,7 +164,7 @@ export default function ExportDialog({ open, onClose }: Props) {
         />
         <Stack pt={1} pb={2} pl={4} width="100%">
           <Typography pb={1} variant="body2">
-            Copy the volume content to a busybox image in the /volume-data
+            Copy the volume content to a data-only image in the /volume-data
             directory.
           </Typography>
           {fromRadioValue === "local-image" && (
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// BundleManifestFile is the name of the manifest at the root of a bundle.
+	BundleManifestFile = "manifest.json"
+	// BundleVolumesDir is the directory of a bundle that holds the archive of every volume.
+	BundleVolumesDir = "volumes"
+	// BundleVersion is the version of the format of the bundles written by the extension.
+	BundleVersion = 1
+)
+
+// BundleManifest describes the volumes of a bundle, which is an uncompressed tar archive
+// that holds the manifest and one compressed archive per volume.
+type BundleManifest struct {
+	Version int            `json:"version"`
+	Volumes []BundleVolume `json:"volumes"`
+}
+
+// BundleVolume describes a volume of a bundle.
+type BundleVolume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// Size is the size of the content of the volume in bytes when it was exported.
+	Size int64 `json:"size"`
+	// Archive is the path of the archive of the volume in the bundle, e.g. "volumes/my-volume.tar.zst".
+	Archive string `json:"archive"`
+}
+
+// BundleArchive returns the path in a bundle of the archive of the volume compressed with the compression.
+func BundleArchive(volumeName string, compression Compression) string {
+	archive := BundleVolumesDir + "/" + volumeName + ".tar"
+	if compression.Program != "" {
+		archive += compression.Ext
+	}
+	return archive
+}
+
+// ParseBundleManifest reads a bundle manifest and checks that it can be imported.
+func ParseBundleManifest(r io.Reader) (BundleManifest, error) {
+	var m BundleManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if m.Version != BundleVersion {
+		return m, fmt.Errorf("unsupported bundle version %d", m.Version)
+	}
+
+	names := make(map[string]bool, len(m.Volumes))
+	for _, v := range m.Volumes {
+		if err := ValidateVolumeName(v.Name); err != nil {
+			return m, fmt.Errorf("invalid bundle manifest: %w", err)
+		}
+		if names[v.Name] {
+			return m, fmt.Errorf("invalid bundle manifest: duplicate volume %q", v.Name)
+		}
+		names[v.Name] = true
+
+		// The archive is given to tar as the name of a member, which must be inside the volumes directory
+		if path.Clean(v.Archive) != v.Archive || !strings.HasPrefix(v.Archive, BundleVolumesDir+"/") {
+			return m, fmt.Errorf("invalid bundle manifest: invalid archive %q for volume %q", v.Archive, v.Name)
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,38 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBundleManifest(t *testing.T) {
+	m, err := ParseBundleManifest(strings.NewReader(`{"version":1,"volumes":[` +
+		`{"name":"db","driver":"local","labels":{"app":"shop"},"size":1000,"archive":"volumes/db.tar.zst"},` +
+		`{"name":"cache","driver":"local","size":0,"archive":"volumes/cache.tar"}]}`))
+	require.NoError(t, err)
+	require.Len(t, m.Volumes, 2)
+	require.Equal(t, BundleVolume{Name: "db", Driver: "local", Labels: map[string]string{"app": "shop"}, Size: 1000, Archive: "volumes/db.tar.zst"}, m.Volumes[0])
+	require.Equal(t, BundleArchive("db", CompressionByExt(".zst")), m.Volumes[0].Archive)
+	require.Equal(t, BundleArchive("cache", NoCompression), m.Volumes[1].Archive)
+}
+
+func TestParseBundleManifestInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not json":               `volumes`,
+		"unknown version":        `{"version":2,"volumes":[]}`,
+		"no name":                `{"version":1,"volumes":[{"archive":"volumes/a.tar"}]}`,
+		"invalid name":           `{"version":1,"volumes":[{"name":"$(reboot)","archive":"volumes/db.tar"}]}`,
+		"duplicate volume":       `{"version":1,"volumes":[{"name":"db","archive":"volumes/db.tar"},{"name":"db","archive":"volumes/logs.tar"}]}`,
+		"archive outside":        `{"version":1,"volumes":[{"name":"db","archive":"volumes/../../etc/passwd"}]}`,
+		"archive not in volumes": `{"version":1,"volumes":[{"name":"db","archive":"manifest.json"}]}`,
+	}
+
+	for name, manifest := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseBundleManifest(strings.NewReader(manifest))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// BackupKind is the kind of a backup listed in the catalog.
+type BackupKind string
+
+const (
+	// BackupImage is a local image saved by Save. It is found by its labels, not tracked by the catalog.
+	BackupImage BackupKind = "image"
+	// BackupArchive is an archive exported to the host.
+	BackupArchive BackupKind = "archive"
+	// BackupOCILayout is an OCI image layout exported to the host, as a directory or a tarball.
+	BackupOCILayout BackupKind = "oci-layout"
+	// BackupBundle is a bundle of several volumes exported to the host. The catalog has one backup per volume of the bundle.
+	BackupBundle BackupKind = "bundle"
+	// BackupRegistry is a reference pushed to a registry.
+	BackupRegistry BackupKind = "registry"
+)
+
+const (
+	// legacyActionLabel and legacyVolumeLabel are labels of the helper containers. The images saved by earlier versions of the extension
+	// were committed from such a container, so they have these labels, with the "save" action, instead of MetadataLabel.
+	legacyActionLabel = "com.volumes-backup-extension.action"
+	legacyVolumeLabel = "com.volumes-backup-extension.volume"
+)
+
+// ErrBackupNotFound is returned when a backup is not in the catalog.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Backup is a backup of a volume listed in the catalog.
+type Backup struct {
+	// ID identifies the backup in the catalog. It is derived from the kind, the volume and the location of the backup.
+	ID   string     `json:"id"`
+	Kind BackupKind `json:"kind"`
+	// Volume is the name of the volume the backup was made from. It can be unknown for the images saved by earlier versions of the extension.
+	Volume string `json:"volume,omitempty"`
+	// Location is the reference of the image, the host path of the archive or layout, or the reference pushed to a registry.
+	Location string    `json:"location"`
+	Created  time.Time `json:"created"`
+	// Size is the size of the image in bytes. It is unknown for the other kinds of backups.
+	Size       int64       `json:"size,omitempty"`
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// NewBackup returns a backup of the volume with its ID.
+func NewBackup(kind BackupKind, volumeName, location string, created time.Time) Backup {
+	return Backup{
+		ID:       digest.FromString(string(kind) + "\x00" + volumeName + "\x00" + location).Hex()[:16],
+		Kind:     kind,
+		Volume:   volumeName,
+		Location: location,
+		Created:  created.UTC(),
+	}
+}
+
+// Catalog lists the backups the extension knows about: the local images saved by Save, found by their labels,
+// and the archives, layouts and references that were tracked when they were made, which the extension can't find by itself.
+type Catalog struct {
+	mu sync.Mutex
+	// path is the JSON file of the tracked backups. If it is empty, they are only kept in memory.
+	path    string
+	tracked []Backup
+}
+
+// NewCatalog returns a catalog whose tracked backups are stored in the JSON file at path, or in memory if path is empty.
+func NewCatalog(path string) *Catalog {
+	return &Catalog{path: path}
+}
+
+// Track adds the backup to the catalog, replacing the backup with the same ID if any, e.g. an archive exported again to the same path.
+func (c *Catalog) Track(backup Backup) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tracked, err := c.load()
+	if err != nil {
+		return err
+	}
+	tracked, _ = withoutBackup(tracked, backup.ID)
+	return c.store(append(tracked, backup))
+}
+
+// Untrack removes the backup from the catalog. It returns ErrBackupNotFound if the backup is not tracked.
+func (c *Catalog) Untrack(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tracked, err := c.load()
+	if err != nil {
+		return err
+	}
+	tracked, found := withoutBackup(tracked, id)
+	if !found {
+		return ErrBackupNotFound
+	}
+	return c.store(tracked)
+}
+
+// withoutBackup returns a copy of the backups without the backup with the ID, and whether it was found.
+func withoutBackup(backups []Backup, id string) ([]Backup, bool) {
+	kept := make([]Backup, 0, len(backups))
+	found := false
+	for _, b := range backups {
+		if b.ID == id {
+			found = true
+			continue
+		}
+		kept = append(kept, b)
+	}
+	return kept, found
+}
+
+// Backups returns all the backups, the most recent first.
+func (c *Catalog) Backups(ctx context.Context, cli *client.Client) ([]Backup, error) {
+	images, err := savedImages(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	tracked, err := c.load()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := append([]Backup{}, tracked...)
+	for _, image := range images {
+		backups = append(backups, imageBackups(image)...)
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Created.After(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// Delete removes the backup from the catalog and returns it. The images are removed, while the archives, layouts and references
+// are only forgotten: their files and the images of the registries are left untouched.
+func (c *Catalog) Delete(ctx context.Context, cli *client.Client, id string) (Backup, error) {
+	backups, err := c.Backups(ctx, cli)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, b := range backups {
+		if b.ID != id {
+			continue
+		}
+		if b.Kind == BackupImage {
+			// A tagged image is only untagged if it has other tags
+			_, err := cli.ImageRemove(ctx, b.Location, types.ImageRemoveOptions{})
+			return b, err
+		}
+		return b, c.Untrack(id)
+	}
+	return Backup{}, ErrBackupNotFound
+}
+
+// savedImages returns the images saved from a volume by Save, and by earlier versions of the extension.
+func savedImages(ctx context.Context, cli *client.Client) ([]types.ImageSummary, error) {
+	// The filters of a list are all applied, so the images are listed once per label
+	var images []types.ImageSummary
+	found := map[string]bool{}
+	for _, label := range []string{MetadataLabel, legacyActionLabel + "=save"} {
+		list, err := cli.ImageList(ctx, types.ImageListOptions{
+			Filters: filters.NewArgs(filters.Arg("label", label)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range list {
+			if !found[image.ID] {
+				found[image.ID] = true
+				images = append(images, image)
+			}
+		}
+	}
+	return images, nil
+}
+
+// imageBackups returns a backup per tag of the image, or a single backup for its ID if it has no tag.
+func imageBackups(image types.ImageSummary) []Backup {
+	provenance, ok, err := ParseProvenance(image.Labels)
+	created := time.Unix(image.Created, 0)
+	if ok && err == nil {
+		created = provenance.Created
+	}
+	volumeName := provenance.Volume
+	if !ok {
+		volumeName = image.Labels[legacyVolumeLabel]
+	}
+
+	locations := image.RepoTags
+	if len(locations) == 0 {
+		locations = []string{image.ID}
+	}
+
+	var backups []Backup
+	for _, location := range locations {
+		if location == "<none>:<none>" {
+			location = image.ID
+		}
+		b := NewBackup(BackupImage, volumeName, location, created)
+		b.Size = image.Size
+		if ok && err == nil {
+			p := provenance
+			b.Provenance = &p
+		}
+		backups = append(backups, b)
+	}
+	return backups
+}
+
+// load returns the tracked backups, reading them from the file of the catalog the first time.
+func (c *Catalog) load() ([]Backup, error) {
+	if c.tracked != nil || c.path == "" {
+		return c.tracked, nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		c.tracked = []Backup{}
+		return c.tracked, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tracked []Backup
+	if err := json.Unmarshal(data, &tracked); err != nil {
+		return nil, err
+	}
+	c.tracked = tracked
+	return c.tracked, nil
+}
+
+// store replaces the tracked backups and writes them to the file of the catalog.
+func (c *Catalog) store(tracked []Backup) error {
+	if c.path == "" {
+		c.tracked = tracked
+		return nil
+	}
+
+	data, err := json.MarshalIndent(tracked, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	// The file is replaced at once, so that it is never left half written
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.tracked = tracked
+	return nil
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogTrack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog", "backups.json")
+	created := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	archive := NewBackup(BackupArchive, "db", "/backups/db.tar.gz", created)
+	pushed := NewBackup(BackupRegistry, "db", "docker.io/acme/db:latest", created)
+
+	c := NewCatalog(path)
+	require.NoError(t, c.Track(archive))
+	require.NoError(t, c.Track(pushed))
+
+	// An archive exported again to the same path replaces the previous one
+	again := NewBackup(BackupArchive, "db", "/backups/db.tar.gz", created.Add(time.Hour))
+	require.Equal(t, archive.ID, again.ID)
+	require.NoError(t, c.Track(again))
+
+	// The tracked backups are read from the file by a new catalog
+	tracked, err := NewCatalog(path).load()
+	require.NoError(t, err)
+	require.Equal(t, []Backup{pushed, again}, tracked)
+
+	require.NoError(t, c.Untrack(pushed.ID))
+	require.ErrorIs(t, c.Untrack(pushed.ID), ErrBackupNotFound)
+	tracked, err = NewCatalog(path).load()
+	require.NoError(t, err)
+	require.Equal(t, []Backup{again}, tracked)
+}
+
+func TestNewBackup(t *testing.T) {
+	created := time.Now()
+
+	require.NotEqual(t, NewBackup(BackupBundle, "db", "/backups/all.tar", created).ID, NewBackup(BackupBundle, "cache", "/backups/all.tar", created).ID)
+	require.NotEqual(t, NewBackup(BackupArchive, "db", "/backups/db", created).ID, NewBackup(BackupOCILayout, "db", "/backups/db", created).ID)
+}
+
+func TestImageBackups(t *testing.T) {
+	created := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	labels := Provenance{Volume: "db", Driver: "local", Created: created}.ImageLabels()
+
+	backups := imageBackups(types.ImageSummary{
+		ID:       "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+		RepoTags: []string{"db:v1", "db:latest"},
+		Labels:   labels,
+		Created:  created.Add(time.Minute).Unix(),
+		Size:     1024,
+	})
+
+	require.Len(t, backups, 2)
+	require.Equal(t, "db:v1", backups[0].Location)
+	require.Equal(t, "db", backups[0].Volume)
+	require.Equal(t, created, backups[0].Created)
+	require.Equal(t, int64(1024), backups[0].Size)
+	require.NotNil(t, backups[0].Provenance)
+
+	// The images saved by earlier versions of the extension have no provenance
+	backups = imageBackups(types.ImageSummary{
+		ID:      "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+		Labels:  map[string]string{MetadataLabel: `{"driver":"local"}`},
+		Created: created.Unix(),
+	})
+
+	require.Len(t, backups, 1)
+	require.Equal(t, "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b", backups[0].Location)
+	require.Empty(t, backups[0].Volume)
+	require.Nil(t, backups[0].Provenance)
+
+	// The images committed from a helper container by earlier versions of the extension have its labels instead
+	backups = imageBackups(types.ImageSummary{
+		ID:       "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+		RepoTags: []string{"db:legacy"},
+		Labels: map[string]string{
+			"com.volumes-backup-extension.action": "save",
+			"com.volumes-backup-extension.volume": "db",
+		},
+		Created: created.Unix(),
+	})
+
+	require.Len(t, backups, 1)
+	require.Equal(t, "db:legacy", backups[0].Location)
+	require.Equal(t, "db", backups[0].Volume)
+	require.Equal(t, created, backups[0].Created)
+	require.Nil(t, backups[0].Provenance)
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// ShellCmd returns the command of a helper container that runs the shell script with args as its positional parameters ("$@").
+// The script must be built from constants only: user-supplied values, e.g. volume names, file names or patterns,
+// are passed in args or in environment variables of the container, so that the shell never parses them.
+func ShellCmd(script string, args ...string) []string {
+	// The first argument after the script is the name of the shell ("$0")
+	return append([]string{"/bin/sh", "-c", script, "sh"}, args...)
+}
+
+// MissingCommandError is returned when a helper image lacks a command, or an option of a command,
+// e.g. an image pulled before the command was added to it.
+type MissingCommandError struct {
+	Image   string
+	Command string
+}
+
+func (e *MissingCommandError) Error() string {
+	return fmt.Sprintf("%s is not available in the helper image %s, the image must be updated", e.Command, e.Image)
+}
+
+// RequireCommand runs cmd in a container of the image, which must be present, and returns a MissingCommandError for the command
+// named name if it fails, e.g. "which xz" if xz is not installed, or "split --numeric-suffixes=1 /dev/null" with busybox split.
+func RequireCommand(ctx context.Context, cli *client.Client, image, name string, cmd ...string) error {
+	// The check is not part of the progress of the action that runs it
+	err := StreamFromContainer(WithProgress(ctx, nil), cli, &container.Config{
+		Image: image,
+		Cmd:   cmd,
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "check",
+		},
+	}, &container.HostConfig{}, io.Discard)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return &MissingCommandError{Image: image, Command: name}
+	}
+	return err
+}
+
+// RequireProgram returns a MissingCommandError if the program of a Compression, e.g. "xz -T0 -6", is not installed in the image.
+// There is nothing to check if program is empty.
+func RequireProgram(ctx context.Context, cli *client.Client, image, program string) error {
+	fields := strings.Fields(program)
+	if len(fields) == 0 {
+		return nil
+	}
+	return RequireCommand(ctx, cli, image, fields[0], "which", fields[0])
+}
@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownCompression is returned when the format of an archive cannot be detected from its first bytes.
+var ErrUnknownCompression = errors.New("unknown archive format")
+
+// UnsetLevel is the level passed to CompressProgram when no level was given, to use the default level of the algorithm.
+// It cannot be 0, which is a valid level for xz.
+const UnsetLevel = -1
+
+// Compression describes an algorithm used to compress archives and how tar runs it in the alpine-tar-zstd image.
+type Compression struct {
+	// Name is the value of the "compression" query parameter, e.g. "zstd".
+	Name string
+	// Ext is the extension of the compressed file, e.g. ".zst".
+	Ext string
+	// ContentType is the media type of the compressed archive.
+	ContentType string
+	// Program is the program tar runs with "-I", which tar invokes with "-d" to decompress.
+	// It is empty when the archive is not compressed.
+	Program string
+	// MinLevel, MaxLevel and DefaultLevel are the compression levels supported by Program.
+	MinLevel, MaxLevel, DefaultLevel int
+
+	magic []byte
+}
+
+// NoCompression is used for plain tar archives.
+var NoCompression = Compression{Name: "none", Ext: ".tar", ContentType: "application/x-tar"}
+
+var compressions = []Compression{
+	// pigz is a parallel implementation of gzip
+	{Name: "gzip", Ext: ".gz", ContentType: "application/gzip", Program: "pigz", MinLevel: 1, MaxLevel: 9, DefaultLevel: 6, magic: []byte{0x1f, 0x8b}},
+	{Name: "zstd", Ext: ".zst", ContentType: "application/zstd", Program: "zstd", MinLevel: 1, MaxLevel: 22, DefaultLevel: 3, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Name: "bzip2", Ext: ".bz2", ContentType: "application/x-bzip2", Program: "bzip2", MinLevel: 1, MaxLevel: 9, DefaultLevel: 9, magic: []byte("BZh")},
+	{Name: "xz", Ext: ".xz", ContentType: "application/x-xz", Program: "xz", MinLevel: 0, MaxLevel: 9, DefaultLevel: 6, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Name: "lz4", Ext: ".lz4", ContentType: "application/x-lz4", Program: "lz4", MinLevel: 1, MaxLevel: 12, DefaultLevel: 1, magic: []byte{0x04, 0x22, 0x4d, 0x18}},
+}
+
+// CompressionByName returns the compression with the given name, e.g. "zstd" or "none".
+func CompressionByName(name string) (Compression, bool) {
+	if name == NoCompression.Name {
+		return NoCompression, true
+	}
+	for _, c := range compressions {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Compression{}, false
+}
+
+// CompressionByExt returns the compression of a file based on its extension, e.g. ".zst".
+// Files with an unknown extension are not compressed.
+func CompressionByExt(fileExt string) Compression {
+	for _, c := range compressions {
+		if c.Ext == fileExt {
+			return c
+		}
+	}
+	return NoCompression
+}
+
+// CompressProgram returns the command tar runs with "-I" to compress an archive at the given level,
+// or an empty string if the archive is not compressed. UnsetLevel means the default level of the algorithm.
+func (c Compression) CompressProgram(level int) (string, error) {
+	if c.Program == "" {
+		return "", nil
+	}
+	if level == UnsetLevel {
+		level = c.DefaultLevel
+	}
+	if level < c.MinLevel || level > c.MaxLevel {
+		return "", fmt.Errorf("level %d is not supported by %s, it must be between %d and %d", level, c.Name, c.MinLevel, c.MaxLevel)
+	}
+
+	switch c.Name {
+	case "gzip":
+		// "-k" to not delete the original file after processing
+		return fmt.Sprintf("pigz -%d -k", level), nil
+	case "zstd":
+		// "-T0" to use as many threads as physical CPU cores, "--ultra" is needed for levels above 19
+		if level > 19 {
+			return fmt.Sprintf("zstd -T0 --ultra -%d", level), nil
+		}
+		return fmt.Sprintf("zstd -T0 -%d", level), nil
+	case "xz":
+		return fmt.Sprintf("xz -T0 -%d", level), nil
+	default:
+		return fmt.Sprintf("%s -%d", c.Program, level), nil
+	}
+}
+
+// DetectCompression detects the compression of an archive from its first bytes (at least 262 bytes are needed to detect an uncompressed tar archive).
+func DetectCompression(header []byte) (Compression, error) {
+	for _, c := range compressions {
+		if bytes.HasPrefix(header, c.magic) {
+			return c, nil
+		}
+	}
+	if len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")) {
+		return NoCompression, nil
+	}
+	return Compression{}, ErrUnknownCompression
+}
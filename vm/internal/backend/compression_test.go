@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressProgram(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    int
+		expected string
+	}{
+		{name: "none", level: UnsetLevel, expected: ""},
+		{name: "gzip", level: UnsetLevel, expected: "pigz -6 -k"},
+		{name: "gzip", level: 9, expected: "pigz -9 -k"},
+		{name: "zstd", level: 19, expected: "zstd -T0 -19"},
+		{name: "zstd", level: 22, expected: "zstd -T0 --ultra -22"},
+		{name: "xz", level: UnsetLevel, expected: "xz -T0 -6"},
+		{name: "xz", level: 0, expected: "xz -T0 -0"},
+		{name: "lz4", level: 12, expected: "lz4 -12"},
+	}
+
+	for _, tt := range tests {
+		c, ok := CompressionByName(tt.name)
+		require.True(t, ok)
+
+		program, err := c.CompressProgram(tt.level)
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, program)
+	}
+
+	zstd, _ := CompressionByName("zstd")
+	_, err := zstd.CompressProgram(23)
+	require.Error(t, err)
+
+	// Level 0 is not the default level of the algorithms that do not support it
+	_, err = zstd.CompressProgram(0)
+	require.Error(t, err)
+}
+
+func TestDetectCompression(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+
+	tests := map[string][]byte{
+		"gzip":  {0x1f, 0x8b, 0x08},
+		"zstd":  {0x28, 0xb5, 0x2f, 0xfd},
+		"bzip2": []byte("BZh9"),
+		"xz":    {0xfd, '7', 'z', 'X', 'Z', 0x00},
+		"lz4":   {0x04, 0x22, 0x4d, 0x18},
+		"none":  tar,
+	}
+
+	for name, header := range tests {
+		c, err := DetectCompression(header)
+		require.NoError(t, err)
+		require.Equal(t, name, c.Name)
+	}
+
+	_, err := DetectCompression([]byte("this is not an archive"))
+	require.ErrorIs(t, err, ErrUnknownCompression)
+}
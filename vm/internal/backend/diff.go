@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffArchiveCmd is the command that tar runs with "--to-command" for every regular file of an archive.
+// It prints one line per file with its size, modification time and path separated by tabs, e.g. "615	1643119380	./index.html".
+// It must be passed to tar through the VACKUP_DIFF_CMD environment variable to avoid nested quoting.
+const DiffArchiveCmd = `cat > /dev/null && printf '%s\t%s\t%s\n' "$TAR_SIZE" "$TAR_MTIME" "$TAR_FILENAME"`
+
+// DiffDirCmd returns the shell command that prints the regular files of the directory dir in the same format as DiffArchiveCmd.
+// It works with the find and stat commands of busybox.
+func DiffDirCmd(dir string) string {
+	return fmt.Sprintf("cd %s && find . -type f -exec stat -c '%%s\t%%Y\t%%n' {} +", dir)
+}
+
+// DiffEntry describes a regular file of an archive, an image or a volume.
+type DiffEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+}
+
+// ParseDiffEntries reads the lines produced by DiffArchiveCmd or DiffDirCmd.
+// The paths are made relative to the root of the volume, e.g. "./index.html" and "index.html" both become "index.html".
+func ParseDiffEntries(r io.Reader) ([]DiffEntry, error) {
+	var entries []DiffEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		s := strings.SplitN(line, "\t", 3) // e.g. 615	1643119380	./index.html
+		if len(s) != 3 {
+			return nil, fmt.Errorf("invalid file line: %q", line)
+		}
+
+		size, err := strconv.ParseInt(s[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in file line %q: %w", line, err)
+		}
+		mtime, err := strconv.ParseInt(s[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modification time in file line %q: %w", line, err)
+		}
+
+		entries = append(entries, DiffEntry{
+			Path:  strings.TrimPrefix(path.Clean("/"+s[2]), "/"),
+			Size:  size,
+			MTime: mtime,
+		})
+	}
+
+	return entries, scanner.Err()
+}
+
+// ParseImageDataDiffEntries reads the tar archive returned by OpenImageData and returns its regular files,
+// with paths relative to the root of the volume.
+func ParseImageDataDiffEntries(r io.Reader) ([]DiffEntry, error) {
+	var entries []DiffEntry
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, ok := volumeDataPath(hdr.Name)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entries = append(entries, DiffEntry{
+			Path:  strings.TrimPrefix(path.Clean("/"+name), "/"),
+			Size:  hdr.Size,
+			MTime: hdr.ModTime.Unix(),
+		})
+	}
+}
+
+// DiffReport describes what importing an archive or loading an image into a volume would change.
+type DiffReport struct {
+	// Added are the files that don't exist in the volume yet.
+	Added []DiffEntry `json:"added"`
+	// Modified are the files of the volume that would be overwritten with a different size or modification time.
+	Modified []DiffEntry `json:"modified"`
+	// Deleted are the files of the volume that would be removed.
+	Deleted []DiffEntry `json:"deleted"`
+	// Unchanged is the number of files of the volume that would be left as they are.
+	Unchanged int `json:"unchanged"`
+
+	AddedBytes    int64 `json:"addedBytes"`
+	ModifiedBytes int64 `json:"modifiedBytes"`
+	DeletedBytes  int64 `json:"deletedBytes"`
+}
+
+// Diff compares the files to import with the current files of the volume, following the import strategy.
+// The entries of Modified are the incoming files, and ModifiedBytes is the size of the incoming files.
+func Diff(incoming, current []DiffEntry, strategy ImportStrategy) DiffReport {
+	report := DiffReport{
+		Added:    []DiffEntry{},
+		Modified: []DiffEntry{},
+		Deleted:  []DiffEntry{},
+	}
+
+	currentByPath := make(map[string]DiffEntry, len(current))
+	for _, e := range current {
+		currentByPath[e.Path] = e
+	}
+
+	for _, e := range incoming {
+		c, ok := currentByPath[e.Path]
+		if !ok {
+			report.Added = append(report.Added, e)
+			report.AddedBytes += e.Size
+			continue
+		}
+		delete(currentByPath, e.Path)
+
+		// With the replace strategy, the file is removed and extracted again, but its content is the same
+		if c == e || strategy == ImportStrategySkipExisting {
+			report.Unchanged++
+			continue
+		}
+		report.Modified = append(report.Modified, e)
+		report.ModifiedBytes += e.Size
+	}
+
+	// The files of the volume that are not part of the import are only removed with the replace strategy
+	for _, c := range currentByPath {
+		if strategy != ImportStrategyReplace {
+			report.Unchanged++
+			continue
+		}
+		report.Deleted = append(report.Deleted, c)
+		report.DeletedBytes += c.Size
+	}
+
+	for _, entries := range [][]DiffEntry{report.Added, report.Modified, report.Deleted} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+
+	return report
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiffEntries(t *testing.T) {
+	entries, err := ParseDiffEntries(strings.NewReader("615\t1643123032\t./index.html\n497\t1643123032\t50x.html\n"))
+	require.NoError(t, err)
+	require.Equal(t, []DiffEntry{
+		{Path: "index.html", Size: 615, MTime: 1643123032},
+		{Path: "50x.html", Size: 497, MTime: 1643123032},
+	}, entries)
+
+	_, err = ParseDiffEntries(strings.NewReader("615 ./index.html\n"))
+	require.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	incoming := []DiffEntry{
+		{Path: "same.txt", Size: 10, MTime: 1},
+		{Path: "changed.txt", Size: 20, MTime: 2},
+		{Path: "new.txt", Size: 30, MTime: 3},
+	}
+	current := []DiffEntry{
+		{Path: "same.txt", Size: 10, MTime: 1},
+		{Path: "changed.txt", Size: 25, MTime: 1},
+		{Path: "old.txt", Size: 40, MTime: 1},
+	}
+
+	report := Diff(incoming, current, ImportStrategyReplace)
+	require.Equal(t, []DiffEntry{{Path: "new.txt", Size: 30, MTime: 3}}, report.Added)
+	require.Equal(t, []DiffEntry{{Path: "changed.txt", Size: 20, MTime: 2}}, report.Modified)
+	require.Equal(t, []DiffEntry{{Path: "old.txt", Size: 40, MTime: 1}}, report.Deleted)
+	require.Equal(t, 1, report.Unchanged)
+	require.Equal(t, int64(30), report.AddedBytes)
+	require.Equal(t, int64(20), report.ModifiedBytes)
+	require.Equal(t, int64(40), report.DeletedBytes)
+
+	report = Diff(incoming, current, ImportStrategyMerge)
+	require.Len(t, report.Modified, 1)
+	require.Empty(t, report.Deleted)
+	require.Equal(t, 2, report.Unchanged)
+
+	report = Diff(incoming, current, ImportStrategySkipExisting)
+	require.Len(t, report.Added, 1)
+	require.Empty(t, report.Modified)
+	require.Empty(t, report.Deleted)
+	require.Equal(t, 3, report.Unchanged)
+}
+
+func TestParseImageDataDiffEntries(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "volume-data/"},
+		{Typeflag: tar.TypeDir, Name: "volume-data/html/"},
+		{Typeflag: tar.TypeReg, Name: "volume-data/html/index.html", Size: 3, ModTime: time.Unix(1643119380, 0)},
+		{Typeflag: tar.TypeSymlink, Name: "volume-data/index.html", Linkname: "html/index.html"},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte("foo"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	entries, err := ParseImageDataDiffEntries(&buf)
+	require.NoError(t, err)
+	require.Equal(t, []DiffEntry{{Path: "html/index.html", Size: 3, MTime: 1643119380}}, entries)
+}
@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// BackupIgnoreFile is the file of a volume that lists, one per line, patterns of files that are never archived.
+// The patterns have the same format as the exclude patterns of a Filter, and apply to the directory of the file and its subdirectories.
+const BackupIgnoreFile = ".backupignore"
+
+// Filter selects the files of a volume that are archived.
+type Filter struct {
+	// Include are glob patterns matched against the path of the files relative to the root of the volume, e.g. "data" or "logs/*.log".
+	// The content of a matching directory is included too. If there is no include pattern, the whole volume is archived.
+	Include []string
+	// Exclude are glob patterns matched against every component of the path of the files, e.g. "*.log" or "cache".
+	// They take precedence over the include patterns.
+	Exclude []string
+}
+
+// excludeFile is the file of the helper container where the exclude patterns of a Filter are written.
+const excludeFile = "/tmp/.vackup-exclude"
+
+// Validate returns an error if one of the patterns of the filter is empty or spans several lines.
+func (f Filter) Validate() error {
+	for _, pattern := range append(f.Include, f.Exclude...) {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.New("filter patterns cannot be empty")
+		}
+		if hasControlChar(pattern) {
+			return fmt.Errorf("invalid filter pattern %q, it must not contain control characters", pattern)
+		}
+	}
+	return nil
+}
+
+// TarScript returns a shell script that runs tar with the options opts from the root of the volume mounted at dir,
+// archiving only the files selected by the filter. The files listed in the .backupignore files of the volume are excluded too.
+// The options are part of the script and must not contain user-supplied values: the patterns of the filter are passed
+// to the helper container with Args and Env. The script needs GNU tar.
+// Prefer TarCmd, which does not need a shell, when the filter has no include pattern.
+func (f Filter) TarScript(dir string, opts ...string) string {
+	script := "cd " + dir + " && "
+	cmd := append([]string{"tar"}, opts...)
+
+	if len(f.Exclude) > 0 {
+		// "-X" reads the exclude patterns from a file, one per line
+		script += fmt.Sprintf(`printf '%%s\n' "$VACKUP_EXCLUDE" > %s && `, excludeFile)
+		cmd = append(cmd, "-X", excludeFile)
+	}
+
+	// The patterns of a .backupignore file apply to its directory and to its subdirectories, and a missing file is ignored
+	cmd = append(cmd, "--exclude-ignore-recursive="+BackupIgnoreFile)
+
+	if len(f.Include) == 0 {
+		cmd = append(cmd, ".")
+		return script + strings.Join(cmd, " ")
+	}
+
+	// find lists the paths matching the find expression given as positional parameters, and tar reads them from stdin when "-T -" is used
+	cmd = append(cmd, "-T", "-")
+	return script + `find . \( "$@" \) -print | ` + strings.Join(cmd, " ")
+}
+
+// TarCmd returns the command that runs tar with the options opts from the root of the volume mounted at dir,
+// as the script returned by TarScript does, but without a shell: every option and pattern is a separate argument,
+// e.g. ProgressTarArgs instead of ProgressTarOpts.
+// It returns false if the filter has include patterns, which are matched by find in the script returned by TarScript.
+func (f Filter) TarCmd(dir string, opts ...string) ([]string, bool) {
+	if len(f.Include) > 0 {
+		return nil, false
+	}
+
+	cmd := append([]string{"tar", "-C", dir}, opts...)
+	for _, pattern := range f.Exclude {
+		cmd = append(cmd, "--exclude="+pattern)
+	}
+	return append(cmd, "--exclude-ignore-recursive="+BackupIgnoreFile, "."), true
+}
+
+// Args returns the positional parameters of the script returned by TarScript: the find expression that matches the include patterns.
+func (f Filter) Args() []string {
+	var args []string
+	for i, pattern := range f.Include {
+		if i > 0 {
+			args = append(args, "-o")
+		}
+		// find prints paths relative to the root of the volume with a leading "./"
+		args = append(args, "-path", "."+path.Clean("/"+pattern))
+	}
+	return args
+}
+
+// Env returns the environment variables of the script returned by TarScript: the exclude patterns, one per line.
+func (f Filter) Env() []string {
+	if len(f.Exclude) == 0 {
+		return nil
+	}
+	return []string{"VACKUP_EXCLUDE=" + strings.Join(f.Exclude, "\n")}
+}
@@ -0,0 +1,40 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterTarScript(t *testing.T) {
+	f := Filter{}
+	require.Equal(t, `cd /vackup-volume && tar -cf - --exclude-ignore-recursive=.backupignore .`, f.TarScript("/vackup-volume", "-cf", "-"))
+	require.Empty(t, f.Args())
+	require.Empty(t, f.Env())
+
+	f = Filter{
+		Include: []string{"data", "/logs/*.log", "$(reboot)"},
+		Exclude: []string{"*.tmp", "it's"},
+	}
+	require.Equal(t, `cd /vackup-volume && printf '%s\n' "$VACKUP_EXCLUDE" > /tmp/.vackup-exclude && find . \( "$@" \) -print | `+
+		`tar -cf - -X /tmp/.vackup-exclude --exclude-ignore-recursive=.backupignore -T -`, f.TarScript("/vackup-volume", "-cf", "-"))
+	require.Equal(t, []string{"-path", "./data", "-o", "-path", "./logs/*.log", "-o", "-path", "./$(reboot)"}, f.Args())
+	require.Equal(t, []string{"VACKUP_EXCLUDE=*.tmp\nit's"}, f.Env())
+}
+
+func TestFilterTarCmd(t *testing.T) {
+	cmd, ok := Filter{Exclude: []string{"*.tmp", "$(reboot)"}}.TarCmd("/vackup-volume", "-I", "zstd -T0 -3", "-cf", "-")
+	require.True(t, ok)
+	require.Equal(t, []string{"tar", "-C", "/vackup-volume", "-I", "zstd -T0 -3", "-cf", "-",
+		"--exclude=*.tmp", "--exclude=$(reboot)", "--exclude-ignore-recursive=.backupignore", "."}, cmd)
+
+	// The include patterns need find
+	_, ok = Filter{Include: []string{"data"}}.TarCmd("/vackup-volume", "-cf", "-")
+	require.False(t, ok)
+}
+
+func TestFilterValidate(t *testing.T) {
+	require.NoError(t, Filter{Include: []string{"data"}, Exclude: []string{"*.log"}}.Validate())
+	require.Error(t, Filter{Exclude: []string{" "}}.Validate())
+	require.Error(t, Filter{Exclude: []string{"*.log\ndata"}}.Validate())
+}
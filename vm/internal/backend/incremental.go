@@ -0,0 +1,317 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/client"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DataIndexFile is the file of the images saved by Save, next to the /volume-data directory, that lists the files of the volume.
+// Save reads the index of a base image to only add the changes of the volume on top of it.
+const DataIndexFile = "volume-index.json"
+
+// ErrNoDataIndex is returned when an image can't be the base of a new image because it has no DataIndexFile,
+// e.g. because it was saved by an earlier version of the extension.
+var ErrNoDataIndex = errors.New("image has no index of the files of the volume")
+
+// DataIndex is the content of the DataIndexFile: the members of the archive of a volume by their path, e.g. "/etc/hosts".
+type DataIndex map[string]IndexEntry
+
+// IndexEntry describes a member of the archive of a volume. Two members with the same entry are considered unchanged.
+type IndexEntry struct {
+	Type byte  `json:"type"`
+	Mode int64 `json:"mode"`
+	UID  int   `json:"uid"`
+	GID  int   `json:"gid"`
+	Size int64 `json:"size"`
+	// ModTime is the modification time in nanoseconds since the Unix epoch.
+	ModTime  int64  `json:"modTime"`
+	Linkname string `json:"linkname,omitempty"`
+}
+
+func newIndexEntry(hdr *tar.Header) IndexEntry {
+	entry := IndexEntry{
+		Type:     hdr.Typeflag,
+		Mode:     hdr.Mode,
+		UID:      hdr.Uid,
+		GID:      hdr.Gid,
+		Size:     hdr.Size,
+		ModTime:  hdr.ModTime.UnixNano(),
+		Linkname: hdr.Linkname,
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		entry.Linkname = indexPath(hdr.Linkname)
+	}
+	return entry
+}
+
+// indexPath returns the path of a member of the archive of a volume in the DataIndex, e.g. "/etc/hosts" for "./etc/hosts".
+func indexPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// ReadDataIndex returns the index of the files of an image saved by Save, see DataIndexFile.
+// It returns ErrNoDataIndex if the image has none.
+func ReadDataIndex(ctx context.Context, cli *client.Client, volumeName, image string) (DataIndex, error) {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, image); err != nil {
+		return nil, err
+	}
+
+	archive, err := openImageFile(ctx, cli, volumeName, image, "/"+DataIndexFile)
+	if client.IsErrNotFound(err) {
+		return nil, fmt.Errorf("%s: %w", image, ErrNoDataIndex)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	if _, err := tr.Next(); err != nil {
+		return nil, err
+	}
+	var index DataIndex
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid %s in image %s: %w", DataIndexFile, image, err)
+	}
+	return index, nil
+}
+
+// writeDataLayer reads the archive of a volume and writes into tw the layer of an image that holds it in the /volume-data directory,
+// followed by the DataIndexFile of the volume.
+// If base is not nil, the layer is added on top of the image with that index: it only holds the members that are new or changed
+// since then, and whiteouts for the files that were deleted. The members whose path is in forced are always written.
+// A hard link is written with its target: if a new link targets an unchanged file, the link is skipped and the target is reported
+// in the missing files of the layer, which must be written again with the missing files forced.
+func writeDataLayer(tw *tar.Writer, r io.Reader, base DataIndex, forced map[string]bool) (dataLayer, error) {
+	var layer dataLayer
+	index := DataIndex{}
+	written := map[string]bool{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return layer, err
+		}
+
+		name := indexPath(hdr.Name)
+		entry := newIndexEntry(hdr)
+		index[name] = entry
+		if hdr.Typeflag == tar.TypeReg {
+			layer.files++
+			layer.size += hdr.Size
+		}
+
+		changed := base == nil || forced[name]
+		if previous, ok := base[name]; !changed && (!ok || previous != entry) {
+			changed = true
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			// A hard link and its target are the same file, so a layer holds either both of them or none
+			if written[entry.Linkname] {
+				changed = true
+			} else if changed {
+				layer.missing = append(layer.missing, entry.Linkname)
+				continue
+			}
+		}
+		if !changed {
+			continue
+		}
+		written[name] = true
+
+		hdr.Name = ociDataPath(hdr.Name, hdr.Typeflag == tar.TypeDir)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = ociDataPath(hdr.Linkname, false)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return layer, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return layer, err
+		}
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return layer, err
+	}
+
+	// Only the topmost deleted directory needs a whiteout, it hides all its files
+	var deleted []string
+	for name := range base {
+		if _, ok := index[name]; ok || name == "/" {
+			continue
+		}
+		if parent, ok := index[path.Dir(name)]; ok && parent.Type == tar.TypeDir {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		whiteout := ociDataPath(path.Dir(name), true) + whiteoutPrefix + path.Base(name)
+		if err := writeTarFile(tw, whiteout, 0, bytes.NewReader(nil), time.Unix(0, 0)); err != nil {
+			return layer, err
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return layer, err
+	}
+	// The index is written with a fixed modification time, so that the layer of an unchanged volume always has the same digest
+	return layer, writeTarFile(tw, DataIndexFile, int64(len(data)), bytes.NewReader(data), time.Unix(0, 0))
+}
+
+// dockerArchiveManifest is an entry of the manifest.json file of the archives written by "docker save" and read by "docker load".
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// writeLayeredImage writes into w the archive of a new image, tagged as image, made of the layers of the base image, read from the
+// "docker save" archive of the base image, and of the layer of the given size read from r.
+// The labels of the new image replace the labels of the base image.
+func writeLayeredImage(w io.Writer, saved io.Reader, baseID string, r io.Reader, size int64, diffID digest.Digest, labels map[string]string, image string, created time.Time) error {
+	baseDigest, err := digest.Parse(baseID)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	tr := tar.NewReader(saved)
+	var manifests []dockerArchiveManifest
+	var config []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Name {
+		case "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifests); err != nil {
+				return fmt.Errorf("invalid manifest.json in the archive of image %s: %w", baseID, err)
+			}
+			continue
+		case "repositories", OCIIndexFile, ocispec.ImageLayoutFile:
+			// They would tag the new image as the base image
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		// The configuration of the image is named after its ID in the legacy and in the OCI formats of "docker save"
+		if hdr.Name == baseDigest.Hex()+".json" || hdr.Name == path.Join(OCIBlobsDir, baseDigest.Algorithm().String(), baseDigest.Hex()) {
+			var buf bytes.Buffer
+			if _, err := io.Copy(tw, io.TeeReader(tr, &buf)); err != nil {
+				return err
+			}
+			config = buf.Bytes()
+			continue
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if len(manifests) != 1 {
+		return fmt.Errorf("the archive of image %s has %d images", baseID, len(manifests))
+	}
+	if config == nil {
+		return fmt.Errorf("the archive of image %s has no configuration", baseID)
+	}
+
+	config, err = layeredImageConfig(config, diffID, labels, created)
+	if err != nil {
+		return err
+	}
+	configName := digest.FromBytes(config).Hex() + ".json"
+	layerName := path.Join(diffID.Hex(), "layer.tar")
+
+	if err := writeTarFile(tw, layerName, size, r, created); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, configName, int64(len(config)), bytes.NewReader(config), created); err != nil {
+		return err
+	}
+	manifest, err := json.Marshal([]dockerArchiveManifest{{
+		Config:   configName,
+		RepoTags: []string{image},
+		Layers:   append(manifests[0].Layers, layerName),
+	}})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", int64(len(manifest)), bytes.NewReader(manifest), created); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// layeredImageConfig returns the configuration of the image made of the base image, whose configuration is given, and of the layer
+// with the diff ID. The fields of the base configuration that are not changed are kept as they are.
+func layeredImageConfig(base []byte, diffID digest.Digest, labels map[string]string, created time.Time) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(base, &config); err != nil {
+		return nil, fmt.Errorf("invalid image configuration: %w", err)
+	}
+
+	var rootFS ocispec.RootFS
+	if err := json.Unmarshal(config["rootfs"], &rootFS); err != nil {
+		return nil, fmt.Errorf("invalid rootfs in image configuration: %w", err)
+	}
+	rootFS.DiffIDs = append(rootFS.DiffIDs, diffID)
+
+	var history []ocispec.History
+	if data, ok := config["history"]; ok {
+		if err := json.Unmarshal(data, &history); err != nil {
+			return nil, fmt.Errorf("invalid history in image configuration: %w", err)
+		}
+	}
+	created = created.UTC()
+	history = append(history, ocispec.History{Created: &created, CreatedBy: "volumes-backup-extension"})
+
+	var imageConfig map[string]json.RawMessage
+	if data, ok := config["config"]; ok {
+		if err := json.Unmarshal(data, &imageConfig); err != nil {
+			return nil, fmt.Errorf("invalid config in image configuration: %w", err)
+		}
+	}
+	if imageConfig == nil {
+		// The configuration of an imported image can be null
+		imageConfig = map[string]json.RawMessage{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+	imageConfig["Labels"] = data
+	for key, value := range map[string]interface{}{"created": created, "rootfs": rootFS, "history": history, "config": imageConfig} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		config[key] = data
+	}
+
+	return json.Marshal(config)
+}
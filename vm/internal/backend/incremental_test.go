@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveOf returns the tar archive of the members, whose regular files have their name as content.
+func archiveOf(t *testing.T, members ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range members {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(hdr.Name))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// layerOf writes the data layer of the archive on top of the base index and returns it with the names of its members.
+func layerOf(t *testing.T, archive []byte, base DataIndex, forced map[string]bool) (dataLayer, map[string]string, DataIndex) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	layer, err := writeDataLayer(tw, bytes.NewReader(archive), base, forced)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	files := tarFiles(t, &buf)
+	var index DataIndex
+	require.NoError(t, json.Unmarshal([]byte(files[DataIndexFile]), &index))
+	delete(files, DataIndexFile)
+	return layer, files, index
+}
+
+func names(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriteDataLayer(t *testing.T) {
+	t1 := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	v1 := archiveOf(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: t1},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755, ModTime: t1},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hosts", Mode: 0644, ModTime: t1},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "./etc/hosts.bak", Linkname: "./etc/hosts", ModTime: t1},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./old/", Mode: 0755, ModTime: t1},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "./old/log", Mode: 0644, ModTime: t1},
+	)
+
+	full, files, index := layerOf(t, v1, nil, nil)
+
+	require.Equal(t, []string{"volume-data/", "volume-data/etc/", "volume-data/etc/hosts", "volume-data/etc/hosts.bak", "volume-data/old/", "volume-data/old/log"}, names(files))
+	require.Len(t, index, 6)
+	require.Equal(t, "/etc/hosts", index["/etc/hosts.bak"].Linkname)
+	require.Equal(t, int64(2), full.files)
+	require.Empty(t, full.missing)
+
+	t.Run("changes", func(t *testing.T) {
+		v2 := archiveOf(t,
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: t2},
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755, ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hosts", Mode: 0644, ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeLink, Name: "./etc/hosts.bak", Linkname: "./etc/hosts", ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "./new", Mode: 0644, ModTime: t2},
+		)
+
+		layer, files, newIndex := layerOf(t, v2, index, nil)
+
+		require.Equal(t, []string{"volume-data/", "volume-data/.wh.old", "volume-data/new"}, names(files))
+		require.Len(t, newIndex, 5)
+		require.Equal(t, int64(2), layer.files)
+		require.Empty(t, layer.missing)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		layer, files, _ := layerOf(t, v1, index, nil)
+
+		require.Empty(t, files)
+		require.Equal(t, int64(2), layer.files)
+	})
+
+	t.Run("new hard link", func(t *testing.T) {
+		v3 := archiveOf(t,
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755, ModTime: t2},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hosts", Mode: 0644, ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeLink, Name: "./etc/hosts.bak", Linkname: "./etc/hosts", ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeLink, Name: "./etc/hosts.new", Linkname: "./etc/hosts", ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./old/", Mode: 0755, ModTime: t1},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "./old/log", Mode: 0644, ModTime: t1},
+		)
+
+		layer, files, _ := layerOf(t, v3, index, nil)
+
+		require.Equal(t, []string{"/etc/hosts"}, layer.missing)
+		require.Equal(t, []string{"volume-data/etc/"}, names(files))
+
+		// The target and all its links are written when the target is forced
+		layer, files, _ = layerOf(t, v3, index, map[string]bool{"/etc/hosts": true})
+
+		require.Empty(t, layer.missing)
+		require.Equal(t, []string{"volume-data/etc/", "volume-data/etc/hosts", "volume-data/etc/hosts.bak", "volume-data/etc/hosts.new"}, names(files))
+	})
+}
+
+func TestWriteLayeredImage(t *testing.T) {
+	baseDiffID := digest.FromString("base layer")
+	baseConfig := []byte(`{"architecture":"arm64","os":"linux","config":{"Labels":{"version":"1"}},"rootfs":{"type":"layers","diff_ids":["` + baseDiffID.String() + `"]},"history":[{"created_by":"import"}]}`)
+	baseID := digest.FromBytes(baseConfig)
+
+	var saved bytes.Buffer
+	tw := tar.NewWriter(&saved)
+	for _, f := range []struct{ name, content string }{
+		{"abc/layer.tar", "base layer"},
+		{baseID.Hex() + ".json", string(baseConfig)},
+		{"manifest.json", `[{"Config":"` + baseID.Hex() + `.json","RepoTags":["db:v1"],"Layers":["abc/layer.tar"]}]`},
+		{"repositories", `{"db":{"v1":"abc"}}`},
+	} {
+		require.NoError(t, writeTarFile(tw, f.name, int64(len(f.content)), bytes.NewReader([]byte(f.content)), time.Now()))
+	}
+	require.NoError(t, tw.Close())
+
+	diffID := digest.FromString("new layer")
+	var archive bytes.Buffer
+	err := writeLayeredImage(&archive, &saved, baseID.String(), bytes.NewReader([]byte("new layer")), 9, diffID,
+		map[string]string{"version": "2"}, "docker.io/library/db:v2", time.Now())
+	require.NoError(t, err)
+
+	files := tarFiles(t, &archive)
+	require.NotContains(t, files, "repositories")
+	require.Equal(t, "base layer", files["abc/layer.tar"])
+	require.Equal(t, "new layer", files[diffID.Hex()+"/layer.tar"])
+
+	var manifests []dockerArchiveManifest
+	require.NoError(t, json.Unmarshal([]byte(files["manifest.json"]), &manifests))
+	require.Len(t, manifests, 1)
+	require.Equal(t, []string{"docker.io/library/db:v2"}, manifests[0].RepoTags)
+	require.Equal(t, []string{"abc/layer.tar", diffID.Hex() + "/layer.tar"}, manifests[0].Layers)
+
+	config := []byte(files[manifests[0].Config])
+	require.Equal(t, digest.FromBytes(config).Hex()+".json", manifests[0].Config)
+	var image ocispec.Image
+	require.NoError(t, json.Unmarshal(config, &image))
+	require.Equal(t, "arm64", image.Architecture)
+	require.Equal(t, []digest.Digest{baseDiffID, diffID}, image.RootFS.DiffIDs)
+	require.Len(t, image.History, 2)
+	require.Equal(t, map[string]string{"version": "2"}, image.Config.Labels)
+}
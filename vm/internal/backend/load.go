@@ -2,25 +2,52 @@ package backend
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
-func Load(ctx context.Context, client *client.Client, volumeName, image string) error {
-	resp, err := client.ContainerCreate(ctx, &container.Config{
-		Image:        image,
-		AttachStdout: true,
-		AttachStderr: true,
-		// remove hidden and not-hidden files and folders:
-		// ..?* matches all dot-dot files except '..'
-		// .[!.]* matches all dot files except '.' and files whose name begins with '..'
-		Cmd: []string{"/bin/sh", "-c", "rm -rf /mount-volume/..?* /mount-volume/.[!.]* /mount-volume/* && cp -Rp /volume-data/. /mount-volume/;"},
+// Load copies the content of the /volume-data directory of the image into the volume, following the import strategy.
+// The image is never run: its content is read from a container created from it but never started, see OpenImageData,
+// and extracted by a helper container. This works for images without a shell and for images of another platform.
+func Load(ctx context.Context, client *client.Client, volumeName, image string, strategy ImportStrategy) error {
+	data, err := OpenImageData(ctx, client, volumeName, image)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	// Ensure the image is present before creating the container
+	reader, err := client.ImagePull(ctx, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The members of the archive are under "volume-data/"
+	tarCmd := append([]string{"tar"}, strategy.TarOpts()...)
+	tarCmd = append(tarCmd, ProgressTarOpts, "--strip-components=1", "-xf", "-", "-C", "/mount-volume")
+	script := strategy.RemoveCmd("/mount-volume") + strings.Join(tarCmd, " ")
+	log.Infof("script: %s", script)
+
+	return StreamIntoContainer(ctx, client, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   ShellCmd(script),
+		User:  "root",
 		Labels: map[string]string{
 			"com.docker.desktop.extension":        "true",
 			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
@@ -33,45 +60,68 @@ func Load(ctx context.Context, client *client.Client, volumeName, image string)
 		Binds: []string{
 			volumeName + ":" + "/mount-volume",
 		},
-	}, nil, nil, "")
-	if err != nil {
-		return err
-	}
-
-	if err := client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return err
-	}
+	}, data)
+}
 
-	var exitCode int64
-	statusCh, errCh := client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
-		}
-	case status := <-statusCh:
-		log.Infof("status: %#+v\n", status)
-		exitCode = status.StatusCode
-	}
+// OpenImageData returns the tar archive of the /volume-data directory of the image, whose members are under "volume-data/".
+// The archive is read from a container created from the image but never started. The container is removed when the archive is closed.
+// It works for the data images saved by Save as well as for the images saved by earlier versions of the extension.
+// The content of an image saved on top of a base image is the content of all its layers: the engine applies the whiteouts of the upper layers.
+func OpenImageData(ctx context.Context, client *client.Client, volumeName, image string) (io.ReadCloser, error) {
+	return openImageFile(ctx, client, volumeName, image, "/"+OCIDataDir)
+}
 
-	out, err := client.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true})
+// openImageFile returns the tar archive of the file or directory at the path of the image.
+// The archive is read from a container created from the image but never started, see OpenImageData.
+func openImageFile(ctx context.Context, client *client.Client, volumeName, image, path string) (io.ReadCloser, error) {
+	inspect, _, err := client.ImageInspectWithRaw(ctx, image)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, out)
+	resp, err := client.ContainerCreate(ctx, &container.Config{
+		Image: image,
+		// Data images have no command, but a container can't be created without one. It is never run.
+		Cmd:        []string{"/volume-data"},
+		Entrypoint: []string{},
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "load",
+			"com.volumes-backup-extension.image":  image,
+			"com.volumes-backup-extension.volume": volumeName,
+		},
+	}, nil, nil, &ocispec.Platform{
+		// The platform of the image, which can differ from the platform of the engine since the container is never started
+		OS:           inspect.Os,
+		Architecture: inspect.Architecture,
+		Variant:      inspect.Variant,
+	}, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if exitCode != 0 {
-		return fmt.Errorf("container exited with status code %d\n", exitCode)
+	remove := func() {
+		_ = client.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
 	}
 
-	err = client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
+	archive, _, err := client.CopyFromContainer(ctx, resp.ID, path)
 	if err != nil {
-		return err
+		remove()
+		return nil, err
 	}
 
-	return nil
+	return &imageDataReader{ReadCloser: archive, remove: remove}, nil
+}
+
+// imageDataReader is the archive of the data of an image, which removes the container it is read from when it is closed.
+type imageDataReader struct {
+	io.ReadCloser
+	remove func()
+}
+
+func (r *imageDataReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.remove()
+	return err
 }
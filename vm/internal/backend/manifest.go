@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestExt is the extension of the manifest file written next to an exported archive.
+const ManifestExt = ".manifest"
+
+// ManifestCmd is the command that tar runs with "--to-command" for every regular file of an archive.
+// It prints one line per file with its SHA-256 checksum, size, mode and path separated by tabs,
+// e.g. "9b2f...c1e3	615	0644	./index.html".
+// It must be passed to tar through the VACKUP_MANIFEST_CMD environment variable to avoid nested quoting.
+const ManifestCmd = `printf '%s\t%s\t%s\t%s\n' "$(sha256sum | cut -d ' ' -f 1)" "$TAR_SIZE" "$TAR_MODE" "$TAR_FILENAME"`
+
+// ManifestEntry describes a regular file of an archive.
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256"`
+}
+
+// ParseManifest reads the lines produced by ManifestCmd.
+func ParseManifest(r io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		s := strings.SplitN(line, "\t", 4) // e.g. 9b2f...c1e3	615	0644	./index.html
+		if len(s) != 4 {
+			return nil, fmt.Errorf("invalid manifest line: %q", line)
+		}
+
+		size, err := strconv.ParseInt(s[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in manifest line %q: %w", line, err)
+		}
+
+		entries = append(entries, ManifestEntry{
+			SHA256: s[0],
+			Size:   size,
+			Mode:   s[2],
+			Path:   s[3],
+		})
+	}
+
+	return entries, scanner.Err()
+}
+
+// VerifyReport is the result of checking the files of an archive against its manifest.
+type VerifyReport struct {
+	Valid bool `json:"valid"`
+	// Files is the number of files listed in the manifest.
+	Files int `json:"files"`
+	// Missing are the files listed in the manifest that are not in the archive.
+	Missing []string `json:"missing,omitempty"`
+	// Mismatched are the files whose size, mode or checksum differ from the manifest.
+	Mismatched []string `json:"mismatched,omitempty"`
+	// Unexpected are the files in the archive that are not listed in the manifest.
+	Unexpected []string `json:"unexpected,omitempty"`
+	// Error is set when the archive could not be read until the end, e.g. because it is truncated.
+	Error string `json:"error,omitempty"`
+}
+
+// CompareManifests compares the manifest of an archive with the entries actually read from it.
+func CompareManifests(expected, actual []ManifestEntry) VerifyReport {
+	report := VerifyReport{
+		Files: len(expected),
+	}
+
+	actualByPath := make(map[string]ManifestEntry, len(actual))
+	for _, e := range actual {
+		actualByPath[e.Path] = e
+	}
+
+	for _, e := range expected {
+		a, ok := actualByPath[e.Path]
+		if !ok {
+			report.Missing = append(report.Missing, e.Path)
+			continue
+		}
+		delete(actualByPath, e.Path)
+
+		if a != e {
+			report.Mismatched = append(report.Mismatched, e.Path)
+		}
+	}
+
+	for p := range actualByPath {
+		report.Unexpected = append(report.Unexpected, p)
+	}
+	sort.Strings(report.Unexpected)
+
+	report.Valid = len(report.Missing) == 0 && len(report.Mismatched) == 0 && len(report.Unexpected) == 0
+
+	return report
+}
@@ -0,0 +1,40 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareManifests(t *testing.T) {
+	manifest := "aaa\t10\t0644\t./a.txt\n" +
+		"bbb\t20\t0644\t./dir/b.txt\n" +
+		"ccc\t30\t0755\t./c.sh\n"
+
+	expected, err := ParseManifest(strings.NewReader(manifest))
+	require.NoError(t, err)
+	require.Len(t, expected, 3)
+	require.Equal(t, ManifestEntry{Path: "./dir/b.txt", Size: 20, Mode: "0644", SHA256: "bbb"}, expected[1])
+
+	report := CompareManifests(expected, expected)
+	require.True(t, report.Valid)
+	require.Equal(t, 3, report.Files)
+
+	actual, err := ParseManifest(strings.NewReader(
+		"aaa\t10\t0644\t./a.txt\n" +
+			"xxx\t20\t0644\t./dir/b.txt\n" +
+			"ddd\t40\t0644\t./d.txt\n"))
+	require.NoError(t, err)
+
+	report = CompareManifests(expected, actual)
+	require.False(t, report.Valid)
+	require.Equal(t, []string{"./c.sh"}, report.Missing)
+	require.Equal(t, []string{"./dir/b.txt"}, report.Mismatched)
+	require.Equal(t, []string{"./d.txt"}, report.Unexpected)
+}
+
+func TestParseManifestInvalidLine(t *testing.T) {
+	_, err := ParseManifest(strings.NewReader("aaa 10 0644 ./a.txt\n"))
+	require.Error(t, err)
+}
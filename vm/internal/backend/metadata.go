@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+const (
+	// MetadataExt is the extension of the metadata file written next to an exported archive.
+	MetadataExt = ".volume.json"
+	// MetadataLabel is the label of the images saved from a volume. Its value is the metadata of the volume in JSON.
+	MetadataLabel = "com.volumes-backup-extension.volume-metadata"
+	// DataImageLabel is the label of the images saved by Save, which only hold the content of a volume, without a shell or any other file.
+	// The images saved by earlier versions of the extension are based on busybox.
+	DataImageLabel = "com.volumes-backup-extension.data-image"
+)
+
+// VolumeMetadata is the metadata of a volume kept in its backups, so that the volume can be created again as it was.
+type VolumeMetadata struct {
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// GetVolumeMetadata returns the metadata of the volume.
+func GetVolumeMetadata(ctx context.Context, cli *client.Client, volumeName string) (VolumeMetadata, error) {
+	v, err := cli.VolumeInspect(ctx, volumeName)
+	if err != nil {
+		return VolumeMetadata{}, err
+	}
+
+	return VolumeMetadata{
+		Driver:     v.Driver,
+		DriverOpts: v.Options,
+		Labels:     v.Labels,
+	}, nil
+}
+
+// ParseVolumeMetadata parses the JSON metadata of a volume.
+func ParseVolumeMetadata(data []byte) (VolumeMetadata, error) {
+	var m VolumeMetadata
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+// String returns the metadata in JSON.
+func (m VolumeMetadata) String() string {
+	// The metadata only holds strings, which are always marshaled
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// tmpfsMountOpts are the mount options of a tmpfs volume of the local driver that only affect the memory it uses and its permissions.
+var tmpfsMountOpts = map[string]bool{"size": true, "nr_blocks": true, "nr_inodes": true, "mode": true, "uid": true, "gid": true}
+
+// SafeDriverOpts returns the driver options that can be applied to a volume created from a backup, which might come from anywhere.
+// The options of the local driver mount any device or host path into the volume, e.g. {"type":"none","o":"bind","device":"/"},
+// so they are kept only for a tmpfs volume with harmless mount options. The options of the other drivers are unknown and dropped.
+// The dropped options are logged.
+func SafeDriverOpts(driver string, opts map[string]string) map[string]string {
+	if len(opts) == 0 {
+		return nil
+	}
+	if (driver == "" || driver == "local") && safeTmpfsOpts(opts) {
+		return opts
+	}
+	log.Warnf("the options %v of driver %q are not applied to the volume", opts, driver)
+	return nil
+}
+
+// safeTmpfsOpts reports whether the options of the local driver create a tmpfs volume with harmless mount options.
+func safeTmpfsOpts(opts map[string]string) bool {
+	for key, value := range opts {
+		switch key {
+		case "type", "device":
+			if value != "tmpfs" {
+				return false
+			}
+		case "o":
+			for _, opt := range strings.Split(value, ",") {
+				if i := strings.Index(opt, "="); i != -1 {
+					opt = opt[:i]
+				}
+				if !tmpfsMountOpts[opt] {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CreateVolumeIfNotExists creates the volume with the driver, the driver options and the labels of the metadata if it does not exist yet.
+// Only the driver options returned by SafeDriverOpts are applied. It reports whether the volume was created.
+func CreateVolumeIfNotExists(ctx context.Context, cli *client.Client, volumeName string, metadata VolumeMetadata) (bool, error) {
+	_, err := cli.VolumeInspect(ctx, volumeName)
+	if err == nil {
+		return false, nil
+	}
+	if !client.IsErrNotFound(err) {
+		return false, err
+	}
+
+	log.Infof("creating volume %s with metadata %s", volumeName, metadata)
+	_, err = cli.VolumeCreate(ctx, volumetypes.CreateOptions{
+		Name:       volumeName,
+		Driver:     metadata.Driver,
+		DriverOpts: SafeDriverOpts(metadata.Driver, metadata.DriverOpts),
+		Labels:     metadata.Labels,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
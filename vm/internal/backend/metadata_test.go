@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVolumeMetadata(t *testing.T) {
+	metadata := VolumeMetadata{
+		Driver:     "local",
+		DriverOpts: map[string]string{"type": "tmpfs", "device": "tmpfs"},
+		Labels:     map[string]string{"com.docker.compose.project": "shop"},
+	}
+
+	parsed, err := ParseVolumeMetadata([]byte(metadata.String()))
+	require.NoError(t, err)
+	require.Equal(t, metadata, parsed)
+
+	_, err = ParseVolumeMetadata([]byte("local"))
+	require.Error(t, err)
+}
+
+func TestSafeDriverOpts(t *testing.T) {
+	tmpfs := map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=100m,uid=1000"}
+	require.Equal(t, tmpfs, SafeDriverOpts("local", tmpfs))
+	require.Equal(t, tmpfs, SafeDriverOpts("", tmpfs))
+
+	tests := map[string]struct {
+		driver string
+		opts   map[string]string
+	}{
+		"bind mount of the host":   {driver: "local", opts: map[string]string{"type": "none", "o": "bind", "device": "/"}},
+		"device of the host":       {driver: "local", opts: map[string]string{"type": "ext4", "device": "/dev/sda1"}},
+		"tmpfs with a bind option": {driver: "local", opts: map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=1m,bind"}},
+		"nfs share":                {driver: "local", opts: map[string]string{"type": "nfs", "o": "addr=10.0.0.1,rw", "device": ":/export"}},
+		"other driver":             {driver: "rexray/ebs", opts: map[string]string{"size": "10"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Nil(t, SafeDriverOpts(tt.driver, tt.opts))
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// OCIDataDir is the directory of the image that holds the content of the volume, as in the images saved with Save.
+	OCIDataDir = "volume-data"
+	// OCIIndexFile is the file of an OCI image layout that lists its manifests.
+	OCIIndexFile = "index.json"
+	// OCIBlobsDir is the directory of an OCI image layout that holds the blobs, named after their digest.
+	OCIBlobsDir = "blobs"
+
+	// ociImageNameAnnotation is the annotation of the full reference of an image, used by containerd and "docker load".
+	ociImageNameAnnotation = "io.containerd.image.name"
+	// ociLayerZstd is the media type of the zstd-compressed layers, which is missing from version 1.0 of the image specification.
+	ociLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+	// dockerLayerGzip is the media type of the gzip-compressed layers of the images built by Docker.
+	dockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	// whiteoutPrefix is the prefix of the files that mark a file of a lower layer as deleted.
+	whiteoutPrefix = ".wh."
+	// opaqueWhiteout is the file that marks the content of its directory in the lower layers as deleted.
+	opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// OCILayer describes the compressed layer built by BuildOCILayer.
+type OCILayer struct {
+	// Digest is the digest of the compressed layer.
+	Digest digest.Digest
+	// DiffID is the digest of the uncompressed layer.
+	DiffID digest.Digest
+	// Size is the size of the compressed layer in bytes.
+	Size int64
+}
+
+// OCIWhiteout is a file of the lower layers that a layer deletes.
+type OCIWhiteout struct {
+	// Path is the path of the deleted file relative to OCIDataDir, e.g. "./etc/hosts".
+	Path string
+	// Opaque is true if only the content of the directory at Path is deleted, and not the directory itself.
+	Opaque bool
+}
+
+// OCIImageOptions are the options of the image written by WriteOCILayout.
+type OCIImageOptions struct {
+	// RefName is the value of the "org.opencontainers.image.ref.name" annotation of the manifest in the index, e.g. "latest".
+	RefName string
+	// ImageName is the full reference of the image, e.g. "docker.io/user/volume:latest", used by "docker load". It can be empty.
+	ImageName string
+	// Metadata is the metadata of the volume, stored in the MetadataLabel label of the image.
+	Metadata VolumeMetadata
+	// Created is the creation date of the image.
+	Created time.Time
+}
+
+// OCIBlobPath returns the path of the blob in an OCI image layout, e.g. "blobs/sha256/9f86d0...".
+// The digest is validated, so the path never leaves the layout.
+func OCIBlobPath(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", d, err)
+	}
+	return path.Join(OCIBlobsDir, d.Algorithm().String(), d.Encoded()), nil
+}
+
+// BuildOCILayer reads the tar archive of the content of a volume from r, e.g. the output of "tar -cf - .", and writes into w
+// the gzip-compressed layer that holds the content under OCIDataDir.
+func BuildOCILayer(r io.Reader, w io.Writer) (OCILayer, error) {
+	blobDigester := digest.SHA256.Digester()
+	cw := &countingWriter{w: io.MultiWriter(w, blobDigester.Hash())}
+	gw := gzip.NewWriter(cw)
+	diffIDDigester := digest.SHA256.Digester()
+	tw := tar.NewWriter(io.MultiWriter(gw, diffIDDigester.Hash()))
+
+	if _, _, err := copyToDataDir(tw, r); err != nil {
+		return OCILayer{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return OCILayer{}, err
+	}
+	if err := gw.Close(); err != nil {
+		return OCILayer{}, err
+	}
+
+	return OCILayer{
+		Digest: blobDigester.Digest(),
+		DiffID: diffIDDigester.Digest(),
+		Size:   cw.n,
+	}, nil
+}
+
+// copyToDataDir reads the tar archive of the content of a volume from r and writes its members into tw under OCIDataDir.
+// It returns the number and the total size of the regular files.
+// The archive is read until EOF, so that the writer of r does not block on the padding after the end of the archive.
+func copyToDataDir(tw *tar.Writer, r io.Reader) (files, size int64, err error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, size, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			files++
+			size += hdr.Size
+		}
+		hdr.Name = ociDataPath(hdr.Name, hdr.Typeflag == tar.TypeDir)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = ociDataPath(hdr.Linkname, false)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return files, size, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return files, size, err
+		}
+	}
+
+	_, err = io.Copy(io.Discard, r)
+	return files, size, err
+}
+
+// ociDataPath returns the name of the member of a layer for the member of the archive of a volume, e.g. "volume-data/etc/hosts" for "./etc/hosts".
+func ociDataPath(name string, dir bool) string {
+	// Cleaning the name as an absolute path drops the leading "./" and any ".." that would escape the volume
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return OCIDataDir + "/"
+	}
+	name = OCIDataDir + name
+	if dir {
+		name += "/"
+	}
+	return name
+}
+
+// WriteOCILayout writes into w the tar archive of an OCI image layout with a single image whose only layer is the layer built by BuildOCILayer,
+// read from blob. It returns the descriptor of the manifest of the image, as listed in the index of the layout.
+func WriteOCILayout(w io.Writer, layer OCILayer, blob io.Reader, opts OCIImageOptions) (ocispec.Descriptor, error) {
+	created := opts.Created.UTC()
+	config, err := json.Marshal(ocispec.Image{
+		Created:      &created,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config: ocispec.ImageConfig{
+			Labels: map[string]string{
+				MetadataLabel: opts.Metadata.String(),
+			},
+		},
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{layer.DiffID},
+		},
+		History: []ocispec.History{
+			{Created: &created, CreatedBy: "volumes-backup-extension"},
+		},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	configDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers: []ocispec.Descriptor{
+			{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: layer.Digest, Size: layer.Size},
+		},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+		Platform:  &ocispec.Platform{Architecture: runtime.GOARCH, OS: "linux"},
+	}
+	manifestDesc.Annotations = map[string]string{}
+	if opts.RefName != "" {
+		manifestDesc.Annotations[ocispec.AnnotationRefName] = opts.RefName
+	}
+	if opts.ImageName != "" {
+		manifestDesc.Annotations[ociImageNameAnnotation] = opts.ImageName
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, dir := range []string{OCIBlobsDir, path.Join(OCIBlobsDir, digest.SHA256.String())} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, ModTime: created}); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	// The layer is written first, so that an interrupted layout has no index
+	layerPath, err := OCIBlobPath(layer.Digest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if err := writeTarFile(tw, layerPath, layer.Size, blob, created); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{path.Join(OCIBlobsDir, digest.SHA256.String(), configDesc.Digest.Encoded()), config},
+		{path.Join(OCIBlobsDir, digest.SHA256.String(), manifestDesc.Digest.Encoded()), manifest},
+		{ocispec.ImageLayoutFile, layout},
+		{OCIIndexFile, index},
+	} {
+		if err := writeTarFile(tw, f.name, int64(len(f.data)), bytes.NewReader(f.data), created); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	return manifestDesc, tw.Close()
+}
+
+// writeTarFile writes a regular file of the given size, read from r, into the tar archive.
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: expected %d bytes, got %d", name, size, n)
+	}
+	return nil
+}
+
+// ParseOCIIndex parses the index.json of an OCI image layout and returns the descriptor of the image manifest annotated with refName,
+// or of its only image manifest if refName is empty.
+func ParseOCIIndex(data []byte, refName string) (ocispec.Descriptor, error) {
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("invalid index: %w", err)
+	}
+	if index.SchemaVersion != 2 {
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported index schema version %d", index.SchemaVersion)
+	}
+
+	var found []ocispec.Descriptor
+	for _, desc := range index.Manifests {
+		if refName != "" && desc.Annotations[ocispec.AnnotationRefName] != refName {
+			continue
+		}
+		found = append(found, desc)
+	}
+
+	switch {
+	case len(found) == 0 && refName != "":
+		return ocispec.Descriptor{}, fmt.Errorf("index has no image %q", refName)
+	case len(found) == 0:
+		return ocispec.Descriptor{}, errors.New("index has no image")
+	case len(found) > 1:
+		return ocispec.Descriptor{}, fmt.Errorf("index has %d images, a reference name is required", len(found))
+	}
+
+	desc := found[0]
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		// Multi-platform images are not supported: a volume is not bound to a platform
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+	}
+	return desc, nil
+}
+
+// ParseOCIManifest checks the image manifest against its descriptor and parses it.
+func ParseOCIManifest(data []byte, desc ocispec.Descriptor) (ocispec.Manifest, error) {
+	var manifest ocispec.Manifest
+	if err := verifyBlob(data, desc); err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return manifest, fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	for _, layer := range manifest.Layers {
+		if _, err := layerDecompressor(layer.MediaType); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// ParseOCIConfig checks the image configuration against its descriptor and returns the metadata of the volume stored in its labels,
+// and whether the image holds metadata.
+func ParseOCIConfig(data []byte, desc ocispec.Descriptor) (VolumeMetadata, bool, error) {
+	if err := verifyBlob(data, desc); err != nil {
+		return VolumeMetadata{}, false, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(data, &config); err != nil {
+		return VolumeMetadata{}, false, fmt.Errorf("invalid image configuration: %w", err)
+	}
+	label := config.Config.Labels[MetadataLabel]
+	if label == "" {
+		return VolumeMetadata{}, false, nil
+	}
+	metadata, err := ParseVolumeMetadata([]byte(label))
+	if err != nil {
+		return VolumeMetadata{}, false, fmt.Errorf("invalid volume metadata: %w", err)
+	}
+	return metadata, true, nil
+}
+
+// verifyBlob returns an error if the blob does not match the size and the digest of its descriptor.
+func verifyBlob(data []byte, desc ocispec.Descriptor) error {
+	if int64(len(data)) != desc.Size {
+		return fmt.Errorf("blob %s: expected %d bytes, got %d", desc.Digest, desc.Size, len(data))
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", desc.Digest, err)
+	}
+	if d := desc.Digest.Algorithm().FromBytes(data); d != desc.Digest {
+		return fmt.Errorf("blob %s: digest mismatch, got %s", desc.Digest, d)
+	}
+	return nil
+}
+
+// layerDecompressor returns the function that decompresses a layer of the media type.
+func layerDecompressor(mediaType string) (func(io.Reader) (io.ReadCloser, error), error) {
+	switch mediaType {
+	case ocispec.MediaTypeImageLayer:
+		return func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }, nil
+	case ocispec.MediaTypeImageLayerGzip, dockerLayerGzip:
+		return func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }, nil
+	case ociLayerZstd:
+		return func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported layer media type %q", mediaType)
+	}
+}
+
+// ExtractOCILayer reads the layer blob described by desc from r and writes into w the tar archive of the files of the layer under OCIDataDir,
+// relative to it, e.g. "./etc/hosts" for "volume-data/etc/hosts". The other files of the layer are ignored.
+// The whiteouts of the layer are not written to the archive: the files of the lower layers they delete are returned instead,
+// and must be removed before the archive is extracted on top of the lower layers.
+// The whole blob is read and an error is returned if it does not match the descriptor, after the archive was written:
+// the archive must be extracted into a staging volume that is discarded on error.
+func ExtractOCILayer(r io.Reader, desc ocispec.Descriptor, w io.Writer) ([]OCIWhiteout, error) {
+	decompress, err := layerDecompressor(desc.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %q: %w", desc.Digest, err)
+	}
+
+	verifier := desc.Digest.Verifier()
+	cr := &countingReader{r: io.TeeReader(r, verifier)}
+	dr, err := decompress(cr)
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", desc.Digest, err)
+	}
+	defer dr.Close()
+
+	var whiteouts []OCIWhiteout
+	tw := tar.NewWriter(w)
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", desc.Digest, err)
+		}
+
+		name, ok := volumeDataPath(hdr.Name)
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+			whiteout, ok, err := parseWhiteout(name)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", desc.Digest, err)
+			}
+			if ok {
+				whiteouts = append(whiteouts, whiteout)
+			}
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			linkname, ok := volumeDataPath(hdr.Linkname)
+			if !ok {
+				return nil, fmt.Errorf("layer %s: hard link %s points outside of %s", desc.Digest, hdr.Name, OCIDataDir)
+			}
+			hdr.Linkname = linkname
+		}
+		if hdr.Typeflag == tar.TypeDir && name != "./" {
+			name += "/"
+		}
+		hdr.Name = name
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", desc.Digest, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	// Read the padding after the end of the archive, so that the digest covers the whole blob
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return nil, fmt.Errorf("layer %s: %w", desc.Digest, err)
+	}
+	if cr.n != desc.Size {
+		return nil, fmt.Errorf("layer %s: expected %d bytes, got %d", desc.Digest, desc.Size, cr.n)
+	}
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("layer %s: digest mismatch", desc.Digest)
+	}
+	return whiteouts, nil
+}
+
+// parseWhiteout returns the file deleted by the whiteout at name, a path relative to OCIDataDir.
+// The other files whose name starts with the prefix of the opaque whiteout are reserved, and false is returned for them.
+func parseWhiteout(name string) (OCIWhiteout, bool, error) {
+	dir, base := path.Dir(name), path.Base(name)
+	if base == opaqueWhiteout {
+		return OCIWhiteout{Path: relativeDataPath(dir), Opaque: true}, true, nil
+	}
+	if strings.HasPrefix(base, whiteoutPrefix+whiteoutPrefix) {
+		return OCIWhiteout{}, false, nil
+	}
+
+	deleted := strings.TrimPrefix(base, whiteoutPrefix)
+	if deleted == "" || deleted == "." || deleted == ".." {
+		return OCIWhiteout{}, false, fmt.Errorf("invalid whiteout %s", name)
+	}
+	return OCIWhiteout{Path: relativeDataPath(path.Join(dir, deleted))}, true, nil
+}
+
+// relativeDataPath returns the clean path relative to OCIDataDir in the format of volumeDataPath, e.g. "./etc/hosts" for "etc/hosts".
+func relativeDataPath(p string) string {
+	if p == "." {
+		return "./"
+	}
+	return "./" + p
+}
+
+// volumeDataPath returns the path relative to OCIDataDir of a member of a layer, e.g. "./etc/hosts" for "volume-data/etc/hosts",
+// and false if the member is not under OCIDataDir.
+func volumeDataPath(name string) (string, bool) {
+	name = path.Clean("/" + name)
+	switch {
+	case name == "/"+OCIDataDir:
+		return "./", true
+	case strings.HasPrefix(name, "/"+OCIDataDir+"/"):
+		return "." + strings.TrimPrefix(name, "/"+OCIDataDir), true
+	default:
+		return "", false
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
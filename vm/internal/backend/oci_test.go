@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// volumeArchive returns a tar archive like the one written by "tar -cf - ." in the root of a volume.
+func volumeArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "./", Mode: 0755},
+		{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755},
+		{Typeflag: tar.TypeReg, Name: "./etc/hosts", Mode: 0644, Size: 9},
+		{Typeflag: tar.TypeLink, Name: "./etc/hosts.bak", Linkname: "./etc/hosts"},
+		{Typeflag: tar.TypeSymlink, Name: "./hosts", Linkname: "etc/hosts"},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte("127.0.0.1"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// tarFiles returns the content of the regular files of the tar archive by name, and the link name of the links.
+func tarFiles(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+
+		switch hdr.Typeflag {
+		case tar.TypeLink, tar.TypeSymlink:
+			files[hdr.Name] = "-> " + hdr.Linkname
+		default:
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = string(b)
+		}
+	}
+}
+
+func TestOCILayout(t *testing.T) {
+	var blob bytes.Buffer
+	layer, err := BuildOCILayer(bytes.NewReader(volumeArchive(t)), &blob)
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(blob.Bytes()), layer.Digest)
+	require.Equal(t, int64(blob.Len()), layer.Size)
+
+	var layout bytes.Buffer
+	manifestDesc, err := WriteOCILayout(&layout, layer, bytes.NewReader(blob.Bytes()), OCIImageOptions{
+		RefName:   "latest",
+		ImageName: "docker.io/user/db:latest",
+		Metadata:  VolumeMetadata{Driver: "local", Labels: map[string]string{"env": "prod"}},
+		Created:   time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	files := tarFiles(t, &layout)
+	require.Equal(t, `{"imageLayoutVersion":"1.0.0"}`, files[ocispec.ImageLayoutFile])
+	layerPath, err := OCIBlobPath(layer.Digest)
+	require.NoError(t, err)
+	require.Equal(t, blob.String(), files[layerPath])
+
+	desc, err := ParseOCIIndex([]byte(files[OCIIndexFile]), "latest")
+	require.NoError(t, err)
+	require.Equal(t, manifestDesc.Digest, desc.Digest)
+	require.Equal(t, "docker.io/user/db:latest", desc.Annotations["io.containerd.image.name"])
+
+	manifestPath, err := OCIBlobPath(desc.Digest)
+	require.NoError(t, err)
+	manifest, err := ParseOCIManifest([]byte(files[manifestPath]), desc)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 1)
+
+	configPath, err := OCIBlobPath(manifest.Config.Digest)
+	require.NoError(t, err)
+	metadata, ok, err := ParseOCIConfig([]byte(files[configPath]), manifest.Config)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, VolumeMetadata{Driver: "local", Labels: map[string]string{"env": "prod"}}, metadata)
+
+	var config ocispec.Image
+	require.NoError(t, json.Unmarshal([]byte(files[configPath]), &config))
+	require.Equal(t, []digest.Digest{layer.DiffID}, config.RootFS.DiffIDs)
+
+	var extracted bytes.Buffer
+	_, err = ExtractOCILayer(bytes.NewReader(blob.Bytes()), manifest.Layers[0], &extracted)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"./":              "",
+		"./etc/":          "",
+		"./etc/hosts":     "127.0.0.1",
+		"./etc/hosts.bak": "-> ./etc/hosts",
+		"./hosts":         "-> etc/hosts",
+	}, tarFiles(t, &extracted))
+}
+
+func TestExtractOCILayer(t *testing.T) {
+	layerOf := func(hdrs ...*tar.Header) ([]byte, ocispec.Descriptor) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			require.NoError(t, tw.WriteHeader(hdr))
+		}
+		require.NoError(t, tw.Close())
+		return buf.Bytes(), ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(buf.Bytes()),
+			Size:      int64(buf.Len()),
+		}
+	}
+
+	t.Run("files outside of the volume data are ignored", func(t *testing.T) {
+		blob, desc := layerOf(
+			&tar.Header{Typeflag: tar.TypeDir, Name: "bin/"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "bin/sh"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data-old/a"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/a"},
+		)
+		var out bytes.Buffer
+		_, err := ExtractOCILayer(bytes.NewReader(blob), desc, &out)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"./a": ""}, tarFiles(t, &out))
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		blob, desc := layerOf(&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/a"})
+		desc.Digest = digest.FromString("something else")
+		_, err := ExtractOCILayer(bytes.NewReader(blob), desc, io.Discard)
+		require.ErrorContains(t, err, "digest mismatch")
+	})
+
+	t.Run("whiteouts", func(t *testing.T) {
+		blob, desc := layerOf(
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/.wh.a"},
+			&tar.Header{Typeflag: tar.TypeDir, Name: "volume-data/b/"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/b/.wh..wh..opq"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/b/c"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/b/.wh..wh.plnk"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/.wh..wh..opq"},
+		)
+		var out bytes.Buffer
+		whiteouts, err := ExtractOCILayer(bytes.NewReader(blob), desc, &out)
+		require.NoError(t, err)
+		require.Equal(t, []OCIWhiteout{
+			{Path: "./a"},
+			{Path: "./b", Opaque: true},
+			{Path: "./", Opaque: true},
+		}, whiteouts)
+		require.Equal(t, map[string]string{"./b/": "", "./b/c": ""}, tarFiles(t, &out))
+	})
+
+	t.Run("whiteout of the parent directory", func(t *testing.T) {
+		blob, desc := layerOf(&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/a/.wh..."})
+		_, err := ExtractOCILayer(bytes.NewReader(blob), desc, io.Discard)
+		require.ErrorContains(t, err, "invalid whiteout")
+	})
+
+	t.Run("hard link outside of the volume data", func(t *testing.T) {
+		blob, desc := layerOf(&tar.Header{Typeflag: tar.TypeLink, Name: "volume-data/passwd", Linkname: "etc/passwd"})
+		_, err := ExtractOCILayer(bytes.NewReader(blob), desc, io.Discard)
+		require.ErrorContains(t, err, "points outside")
+	})
+
+	t.Run("unsupported media type", func(t *testing.T) {
+		blob, desc := layerOf()
+		desc.MediaType = "application/octet-stream"
+		_, err := ExtractOCILayer(bytes.NewReader(blob), desc, io.Discard)
+		require.ErrorContains(t, err, "unsupported layer media type")
+	})
+}
+
+func TestParseOCIIndex(t *testing.T) {
+	index := `{"schemaVersion":2,"manifests":[` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","size":1,"annotations":{"org.opencontainers.image.ref.name":"v1"}},` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752","size":1,"annotations":{"org.opencontainers.image.ref.name":"v2"}}]}`
+
+	desc, err := ParseOCIIndex([]byte(index), "v2")
+	require.NoError(t, err)
+	require.Equal(t, digest.Digest("sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"), desc.Digest)
+
+	_, err = ParseOCIIndex([]byte(index), "")
+	require.ErrorContains(t, err, "a reference name is required")
+
+	_, err = ParseOCIIndex([]byte(index), "v3")
+	require.ErrorContains(t, err, `index has no image "v3"`)
+
+	_, err = ParseOCIIndex([]byte(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","size":1}]}`), "")
+	require.ErrorContains(t, err, "unsupported manifest media type")
+}
+
+func TestOCIBlobPath(t *testing.T) {
+	p, err := OCIBlobPath("sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+	require.NoError(t, err)
+	require.Equal(t, "blobs/sha256/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", p)
+
+	_, err = OCIBlobPath("sha256:../../etc/passwd")
+	require.Error(t, err)
+}
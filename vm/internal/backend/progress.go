@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressTarOpts are the options of GNU tar that print the long listing of the archived or extracted files on stderr, for a Progress to parse.
+// Busybox tar prints the same listing on stdout with "-vv", which must be redirected to stderr.
+const ProgressTarOpts = "-vv --index-file=/dev/stderr"
+
+// ProgressTarArgs are the ProgressTarOpts as separate arguments, for the commands that do not run in a shell.
+var ProgressTarArgs = strings.Fields(ProgressTarOpts)
+
+// listingRegexp matches a line of the long listing printed by GNU tar and busybox tar with "-vv" and captures the size of the file,
+// e.g. "-rw-r--r-- root/root       615 2022-06-01 10:00 ./index.html" or "-rw-r--r-- 0/0 615 2022-06-01 10:00:00 ./index.html".
+var listingRegexp = regexp.MustCompile(`^([-dlcbphs])[-rwxsStT]{9}\S* +\S+ +(\d+) \d{4}-\d{2}-\d{2} \d{2}:\d{2}`)
+
+// maxListingLine is the maximum length of a line of the listing, longer lines are ignored.
+const maxListingLine = 64 * 1024
+
+// Progress tracks the progress of an action on a volume, e.g. an export, from the listing of the files printed by tar in the helper containers.
+// It is safe for concurrent use.
+type Progress struct {
+	mu         sync.Mutex
+	action     string
+	totalBytes int64
+	bytes      int64
+	files      int64
+	startedAt  time.Time
+	// layers are the layers of an image pushed to or pulled from a registry by ID, see TransferLayer.
+	layers map[string]LayerProgress
+}
+
+// ProgressStatus is a snapshot of a Progress.
+type ProgressStatus struct {
+	Action string `json:"action"`
+	// Bytes is the size of the regular files processed so far.
+	Bytes int64 `json:"bytes"`
+	// TotalBytes is the size of all the files to process, or 0 if it is unknown.
+	TotalBytes int64 `json:"totalBytes"`
+	// Files is the number of regular files processed so far.
+	Files int64 `json:"files"`
+	// ETA is the estimated number of seconds until the action completes, or -1 if it cannot be estimated yet.
+	ETA int64 `json:"eta"`
+	// Layers are the layers of an image pushed to or pulled from a registry by ID. Their bytes are part of Bytes and TotalBytes.
+	Layers map[string]LayerProgress `json:"layers,omitempty"`
+}
+
+// LayerProgress is the transfer of a layer of an image to or from a registry.
+type LayerProgress struct {
+	// Bytes is the number of bytes of the compressed layer transferred so far.
+	Bytes int64 `json:"bytes"`
+	// TotalBytes is the size of the compressed layer.
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+// NewProgress returns the progress of the action, started now. totalBytes is the size of all the files to process, or 0 if it is unknown.
+func NewProgress(action string, totalBytes int64) *Progress {
+	return &Progress{
+		action:     action,
+		totalBytes: totalBytes,
+		startedAt:  time.Now(),
+	}
+}
+
+// Status returns the current status of the progress.
+func (p *Progress) Status() ProgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := ProgressStatus{
+		Action:     p.action,
+		Bytes:      p.bytes,
+		TotalBytes: p.totalBytes,
+		Files:      p.files,
+		ETA:        -1,
+	}
+	if len(p.layers) > 0 {
+		status.Layers = make(map[string]LayerProgress, len(p.layers))
+		for id, layer := range p.layers {
+			status.Layers[id] = layer
+			status.Bytes += layer.Bytes
+			status.TotalBytes += layer.TotalBytes
+		}
+	}
+
+	switch {
+	case status.TotalBytes == 0 || status.Bytes == 0:
+	case status.Bytes >= status.TotalBytes:
+		status.ETA = 0
+	default:
+		// The remaining bytes are expected to be processed at the average rate so far
+		elapsed := time.Since(p.startedAt)
+		remaining := time.Duration(float64(elapsed) * float64(status.TotalBytes-status.Bytes) / float64(status.Bytes))
+		status.ETA = int64(remaining.Round(time.Second) / time.Second)
+	}
+
+	return status
+}
+
+// Writer returns a writer that parses the listing written into it, one line at a time, and adds the regular files to the progress.
+// The lines that are not part of a listing, e.g. errors, are ignored. Every stream must be written into its own writer.
+func (p *Progress) Writer() io.Writer {
+	return &listingWriter{progress: p}
+}
+
+// TransferLayer reports the number of bytes of the layer with the ID transferred to or from a registry so far, out of total.
+func (p *Progress) TransferLayer(id string, bytes, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.layers == nil {
+		p.layers = make(map[string]LayerProgress)
+	}
+	p.layers[id] = LayerProgress{Bytes: bytes, TotalBytes: total}
+}
+
+// CompleteLayer reports that all the bytes of the layer with the ID were transferred, since the last reported transfer
+// can be short of the size of the layer.
+func (p *Progress) CompleteLayer(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if layer, ok := p.layers[id]; ok {
+		p.layers[id] = LayerProgress{Bytes: layer.TotalBytes, TotalBytes: layer.TotalBytes}
+	}
+}
+
+func (p *Progress) add(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bytes += size
+	p.files++
+}
+
+// listingWriter splits what is written into it into lines and adds the regular files of the listing to the progress.
+type listingWriter struct {
+	progress *Progress
+	line     []byte
+	skip     bool // whether the current line is too long and is ignored
+}
+
+func (w *listingWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			w.append(b)
+			break
+		}
+		w.append(b[:i])
+		if !w.skip {
+			w.parse(w.line)
+		}
+		w.line, w.skip = w.line[:0], false
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+func (w *listingWriter) append(b []byte) {
+	if w.skip {
+		return
+	}
+	if len(w.line)+len(b) > maxListingLine {
+		w.line, w.skip = w.line[:0], true
+		return
+	}
+	w.line = append(w.line, b...)
+}
+
+func (w *listingWriter) parse(line []byte) {
+	m := listingRegexp.FindSubmatch(line)
+	if m == nil || string(m[1]) != "-" {
+		// Only the regular files are counted: the size of directories and links is not part of the size of the volume
+		return
+	}
+	size, err := strconv.ParseInt(string(m[2]), 10, 64)
+	if err != nil {
+		return
+	}
+	w.progress.add(size)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx in which the helper containers report to the progress, or report to no progress if p is nil.
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// stderrWriter returns the writer of the stderr of the helper containers: the standard error of the backend,
+// and the progress of the context, if any.
+func stderrWriter(ctx context.Context) io.Writer {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	if p == nil {
+		return os.Stderr
+	}
+	return io.MultiWriter(os.Stderr, p.Writer())
+}
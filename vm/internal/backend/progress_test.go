@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressWriter(t *testing.T) {
+	p := NewProgress("export", 2000)
+	w := p.Writer()
+
+	// GNU tar
+	_, err := io.WriteString(w, "drwxr-xr-x root/root         0 2022-06-01 10:00 ./\n-rw-r--r-- root/root       615 2022-06-01 10:00 ./index.html\n")
+	require.NoError(t, err)
+	// Busybox tar, written in several chunks
+	_, err = io.WriteString(w, "-rw-r--r-- 0/0 38")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "5 2022-06-01 10:00:00 ./my file.txt\nlrwxrwxrwx 0/0 0 2022-06-01 10:00:00 ./link -> index.html\n")
+	require.NoError(t, err)
+	// Lines that are not part of a listing, and a line that is too long
+	_, err = io.WriteString(w, "tar: vackup-volume: Not found in archive\n./index.html\n-rw-r--r-- 0/0 1000 2022-06-01 10:00:00 ./"+strings.Repeat("a", maxListingLine)+"\n")
+	require.NoError(t, err)
+
+	status := p.Status()
+	require.Equal(t, "export", status.Action)
+	require.Equal(t, int64(1000), status.Bytes)
+	require.Equal(t, int64(2000), status.TotalBytes)
+	require.Equal(t, int64(2), status.Files)
+	require.GreaterOrEqual(t, status.ETA, int64(0))
+}
+
+func TestProgressETA(t *testing.T) {
+	p := NewProgress("import", 0)
+	p.add(100)
+	require.Equal(t, int64(-1), p.Status().ETA, "total is unknown")
+
+	p = NewProgress("import", 300)
+	require.Equal(t, int64(-1), p.Status().ETA, "nothing processed yet")
+
+	p.startedAt = time.Now().Add(-10 * time.Second)
+	p.add(100)
+	require.InDelta(t, 20, p.Status().ETA, 1)
+
+	p.add(300)
+	require.Equal(t, int64(0), p.Status().ETA)
+}
+
+func TestProgressLayers(t *testing.T) {
+	p := NewProgress("push", 0)
+	p.startedAt = time.Now().Add(-10 * time.Second)
+
+	p.TransferLayer("a1b2c3d4e5f6", 100, 400)
+	p.TransferLayer("f6e5d4c3b2a1", 100, 100)
+	status := p.Status()
+	require.Equal(t, int64(200), status.Bytes)
+	require.Equal(t, int64(500), status.TotalBytes)
+	require.Len(t, status.Layers, 2)
+	require.InDelta(t, 15, status.ETA, 1)
+
+	p.CompleteLayer("a1b2c3d4e5f6")
+	p.CompleteLayer("unknown")
+	status = p.Status()
+	require.Equal(t, int64(500), status.Bytes)
+	require.Len(t, status.Layers, 2)
+	require.Equal(t, int64(0), status.ETA)
+}
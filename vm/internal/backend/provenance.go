@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Labels of the images saved by Save that describe where their content comes from.
+const (
+	// SourceVolumeLabel is the name of the volume the image was saved from.
+	SourceVolumeLabel = "com.volumes-backup-extension.source.volume"
+	// SourceHostLabel is the name of the Docker engine the volume was saved from.
+	SourceHostLabel = "com.volumes-backup-extension.source.host"
+	// SourceDriverLabel is the driver of the volume the image was saved from. The labels of the volume are part of its metadata, see MetadataLabel.
+	SourceDriverLabel = "com.volumes-backup-extension.source.driver"
+	// SizeLabel is the total size in bytes of the regular files saved in the image.
+	SizeLabel = "com.volumes-backup-extension.size"
+	// FilesLabel is the number of regular files saved in the image.
+	FilesLabel = "com.volumes-backup-extension.files"
+	// VersionLabel is the version of the extension that saved the image.
+	VersionLabel = "com.volumes-backup-extension.version"
+	// ContentDigestLabel is the digest of the uncompressed layer that holds the content of the volume, i.e. its diff ID.
+	ContentDigestLabel = "com.volumes-backup-extension.content-digest"
+	// BaseLabel is the ID of the image the image was saved on top of, if it was saved incrementally.
+	BaseLabel = "com.volumes-backup-extension.base"
+	// CreatedLabel is the date and time the image was saved at, in RFC 3339 format.
+	CreatedLabel = "org.opencontainers.image.created"
+)
+
+// Provenance describes where the content of an image saved by Save comes from.
+type Provenance struct {
+	Volume string            `json:"volume"`
+	Host   string            `json:"host,omitempty"`
+	Driver string            `json:"driver"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Size is the total size in bytes of the regular files of the volume.
+	Size int64 `json:"size"`
+	// Files is the number of regular files of the volume.
+	Files   int64     `json:"files"`
+	Created time.Time `json:"created"`
+	// Version is the version of the extension that saved the image.
+	Version string `json:"version"`
+	// ContentDigest is the digest of the uncompressed layer that holds the content of the volume.
+	ContentDigest string `json:"contentDigest"`
+	// Base is the ID of the image the image was saved on top of, if any. The content digest is the digest of the layer that holds
+	// the changes of the volume since the base image was saved.
+	Base string `json:"base,omitempty"`
+}
+
+// ImageLabels returns the labels of the image that store the provenance. The labels of the volume are stored with MetadataLabel.
+func (p Provenance) ImageLabels() map[string]string {
+	labels := map[string]string{
+		SourceVolumeLabel:  p.Volume,
+		SourceDriverLabel:  p.Driver,
+		SizeLabel:          strconv.FormatInt(p.Size, 10),
+		FilesLabel:         strconv.FormatInt(p.Files, 10),
+		CreatedLabel:       p.Created.UTC().Format(time.RFC3339),
+		VersionLabel:       p.Version,
+		ContentDigestLabel: p.ContentDigest,
+	}
+	if p.Host != "" {
+		labels[SourceHostLabel] = p.Host
+	}
+	if p.Base != "" {
+		labels[BaseLabel] = p.Base
+	}
+	return labels
+}
+
+// ParseProvenance returns the provenance stored in the labels of an image, and false if the image was saved by a version
+// of the extension that did not store it.
+func ParseProvenance(labels map[string]string) (Provenance, bool, error) {
+	if labels[SourceVolumeLabel] == "" {
+		return Provenance{}, false, nil
+	}
+
+	p := Provenance{
+		Volume:        labels[SourceVolumeLabel],
+		Host:          labels[SourceHostLabel],
+		Driver:        labels[SourceDriverLabel],
+		Version:       labels[VersionLabel],
+		ContentDigest: labels[ContentDigestLabel],
+		Base:          labels[BaseLabel],
+	}
+
+	var err error
+	if p.Size, err = strconv.ParseInt(labels[SizeLabel], 10, 64); err != nil {
+		return p, true, fmt.Errorf("invalid %s label: %w", SizeLabel, err)
+	}
+	if p.Files, err = strconv.ParseInt(labels[FilesLabel], 10, 64); err != nil {
+		return p, true, fmt.Errorf("invalid %s label: %w", FilesLabel, err)
+	}
+	if p.Created, err = time.Parse(time.RFC3339, labels[CreatedLabel]); err != nil {
+		return p, true, fmt.Errorf("invalid %s label: %w", CreatedLabel, err)
+	}
+	if labels[MetadataLabel] != "" {
+		metadata, err := ParseVolumeMetadata([]byte(labels[MetadataLabel]))
+		if err != nil {
+			return p, true, fmt.Errorf("invalid %s label: %w", MetadataLabel, err)
+		}
+		p.Labels = metadata.Labels
+	}
+
+	return p, true, nil
+}
+
+// Warnings returns the differences between the provenance of an image and the volume it is restored into,
+// described by its name and, if it exists, its metadata. diffIDs are the digests of the uncompressed layers of the image,
+// which must contain the content digest of the provenance if the image was not modified since it was saved.
+func (p Provenance) Warnings(volumeName string, target *VolumeMetadata, diffIDs []string) []string {
+	var warnings []string
+
+	if p.Volume != volumeName {
+		warnings = append(warnings, fmt.Sprintf("the image was saved from volume %q, not %q", p.Volume, volumeName))
+	}
+	if target != nil && target.Driver != p.Driver {
+		warnings = append(warnings, fmt.Sprintf("the image was saved from a volume with driver %q, volume %q uses driver %q", p.Driver, volumeName, target.Driver))
+	}
+
+	found := false
+	for _, diffID := range diffIDs {
+		if diffID == p.ContentDigest {
+			found = true
+			break
+		}
+	}
+	if p.ContentDigest != "" && !found {
+		warnings = append(warnings, fmt.Sprintf("the content of the image does not match the digest %s it was saved with", p.ContentDigest))
+	}
+
+	return warnings
+}
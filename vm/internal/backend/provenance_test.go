@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProvenance(t *testing.T) {
+	metadata := VolumeMetadata{Driver: "local", Labels: map[string]string{"com.docker.compose.project": "shop"}}
+	provenance := Provenance{
+		Volume:        "db",
+		Host:          "docker-desktop",
+		Driver:        "local",
+		Labels:        metadata.Labels,
+		Size:          1024,
+		Files:         3,
+		Created:       time.Date(2022, 9, 1, 10, 30, 0, 0, time.UTC),
+		Version:       "1.0.0",
+		ContentDigest: "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+		Base:          "sha256:1b7cbb3b5ec8c1b3a3d2c3f2e1f5c4a4c8d0e5b7f6a9d2c1e3b4a5f6d7c8e9f0",
+	}
+	labels := provenance.ImageLabels()
+	labels[MetadataLabel] = metadata.String()
+
+	parsed, ok, err := ParseProvenance(labels)
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, provenance, parsed)
+}
+
+func TestParseProvenanceWithoutLabels(t *testing.T) {
+	_, ok, err := ParseProvenance(map[string]string{MetadataLabel: `{"driver":"local"}`})
+
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestParseProvenanceWithInvalidLabelsShouldFail(t *testing.T) {
+	labels := Provenance{Volume: "db", Driver: "local", Created: time.Now()}.ImageLabels()
+	labels[SizeLabel] = "big"
+
+	_, ok, err := ParseProvenance(labels)
+
+	require.Error(t, err)
+	require.True(t, ok)
+}
+
+func TestProvenanceWarnings(t *testing.T) {
+	contentDigest := "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"
+	provenance := Provenance{Volume: "db", Driver: "local", ContentDigest: contentDigest}
+
+	tests := map[string]struct {
+		volume   string
+		target   *VolumeMetadata
+		diffIDs  []string
+		warnings int
+	}{
+		"same volume":        {volume: "db", target: &VolumeMetadata{Driver: "local"}, diffIDs: []string{contentDigest}},
+		"new volume":         {volume: "db", diffIDs: []string{contentDigest}},
+		"other volume":       {volume: "cache", diffIDs: []string{contentDigest}, warnings: 1},
+		"other driver":       {volume: "db", target: &VolumeMetadata{Driver: "nfs"}, diffIDs: []string{contentDigest}, warnings: 1},
+		"modified content":   {volume: "db", diffIDs: []string{"sha256:0000000000000000000000000000000000000000000000000000000000000000"}, warnings: 1},
+		"everything differs": {volume: "cache", target: &VolumeMetadata{Driver: "nfs"}, warnings: 3},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Len(t, provenance.Warnings(tt.volume, tt.target, tt.diffIDs), tt.warnings)
+		})
+	}
+}
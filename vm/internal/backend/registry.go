@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// RegistryError is the first error reported by the engine while it pushes an image to or pulls an image from a registry.
+type RegistryError struct {
+	// StatusCode is the HTTP status code to respond with, e.g. 401 Unauthorized if the credentials are missing or wrong.
+	StatusCode int
+	Message    string
+}
+
+func (e *RegistryError) Error() string {
+	return e.Message
+}
+
+// newRegistryError returns the RegistryError of an error of the JSON message stream of a push or a pull.
+func newRegistryError(jsonErr *jsonmessage.JSONError) *RegistryError {
+	message := strings.ToLower(jsonErr.Message)
+	statusCode := http.StatusInternalServerError
+	switch {
+	case strings.Contains(message, "unauthorized"), strings.Contains(message, "authentication required"),
+		strings.Contains(message, "no basic auth credentials"), strings.Contains(message, "denied"):
+		statusCode = http.StatusUnauthorized
+	case strings.Contains(message, "not found"), strings.Contains(message, "manifest unknown"):
+		statusCode = http.StatusNotFound
+	case strings.Contains(message, "toomanyrequests"):
+		statusCode = http.StatusTooManyRequests
+	}
+	return &RegistryError{StatusCode: statusCode, Message: jsonErr.Message}
+}
+
+// ReadRegistryStream reads the JSON message stream of the engine while it pushes or pulls an image, as the messages are received.
+// The bytes of every layer pushed or pulled are reported to the progress, if not nil, and the other messages are logged.
+// It returns a *RegistryError as soon as the stream reports an error, and the caller must close the stream to abort the push or the pull.
+func ReadRegistryStream(r io.Reader, progress *Progress) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return newRegistryError(msg.Error)
+		}
+
+		switch {
+		case msg.ID != "" && msg.Progress != nil && (msg.Status == "Pushing" || msg.Status == "Downloading"):
+			// The progress of the extraction of the pulled layers is not a transfer
+			if progress != nil {
+				progress.TransferLayer(msg.ID, msg.Progress.Current, msg.Progress.Total)
+			}
+		case msg.ID != "" && (msg.Status == "Pushed" || msg.Status == "Download complete"):
+			if progress != nil {
+				progress.CompleteLayer(msg.ID)
+			}
+			log.Infof("%s: %s", msg.ID, msg.Status)
+		case msg.ID != "":
+			log.Infof("%s: %s", msg.ID, msg.Status)
+		case msg.Status != "":
+			log.Info(msg.Status)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRegistryStream(t *testing.T) {
+	p := NewProgress("pull", 0)
+
+	err := ReadRegistryStream(strings.NewReader(`{"status":"Pulling from acme/db","id":"latest"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a1b2c3d4e5f6"}
+{"status":"Downloading","progressDetail":{"current":512,"total":2048},"progress":"[=====>   ]","id":"a1b2c3d4e5f6"}
+{"status":"Downloading","progressDetail":{"current":2000,"total":2048},"progress":"[========>]","id":"a1b2c3d4e5f6"}
+{"status":"Download complete","progressDetail":{},"id":"a1b2c3d4e5f6"}
+{"status":"Extracting","progressDetail":{"current":4096,"total":8192},"progress":"[====>    ]","id":"a1b2c3d4e5f6"}
+{"status":"Pull complete","progressDetail":{},"id":"a1b2c3d4e5f6"}
+{"status":"Status: Downloaded newer image for acme/db:latest"}
+`), p)
+
+	require.NoError(t, err)
+	status := p.Status()
+	require.Equal(t, map[string]LayerProgress{"a1b2c3d4e5f6": {Bytes: 2048, TotalBytes: 2048}}, status.Layers)
+	require.Equal(t, int64(2048), status.Bytes)
+	require.Equal(t, int64(2048), status.TotalBytes)
+}
+
+func TestReadRegistryStreamShouldStopAtFirstError(t *testing.T) {
+	tests := map[string]struct {
+		message    string
+		statusCode int
+	}{
+		"unauthorized":   {message: "unauthorized: authentication required", statusCode: http.StatusUnauthorized},
+		"no credentials": {message: "no basic auth credentials", statusCode: http.StatusUnauthorized},
+		"not found":      {message: "manifest for acme/db:latest not found: manifest unknown: manifest unknown", statusCode: http.StatusNotFound},
+		"rate limit":     {message: "toomanyrequests: You have reached your pull rate limit", statusCode: http.StatusTooManyRequests},
+		"other":          {message: "received unexpected HTTP status: 500 Internal Server Error", statusCode: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The stream is still open when the error is received, as the registry would keep it open
+			r, w := io.Pipe()
+			defer w.Close()
+			done := make(chan error)
+			go func() {
+				done <- ReadRegistryStream(r, nil)
+			}()
+			_, err := io.WriteString(w, `{"status":"Pushing","progressDetail":{"current":512,"total":2048},"id":"a1b2c3d4e5f6"}
+{"errorDetail":{"message":"`+tt.message+`"},"error":"`+tt.message+`"}
+`)
+			require.NoError(t, err)
+
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the stream is still read after the error")
+			}
+			var registryErr *RegistryError
+			require.ErrorAs(t, err, &registryErr)
+			require.Equal(t, tt.statusCode, registryErr.StatusCode)
+			require.Equal(t, tt.message, registryErr.Error())
+		})
+	}
+}
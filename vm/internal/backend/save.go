@@ -1,78 +1,326 @@
 package backend
 
 import (
+	"archive/tar"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/pkg/jsonmessage"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
-func Save(ctx context.Context, client *client.Client, volumeName, image string) error {
-	resp, err := client.ContainerCreate(ctx, &container.Config{
-		Image:        internal.BusyboxImage,
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", "cp -Rp -v /mount-volume/. /volume-data/;"},
-		Labels: map[string]string{
-			"com.docker.desktop.extension":        "true",
-			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
-			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
-			"com.volumes-backup-extension.action": "save",
-			"com.volumes-backup-extension.image":  image,
-			"com.volumes-backup-extension.volume": volumeName,
-		},
-	}, &container.HostConfig{
-		Binds: []string{
-			volumeName + ":" + "/mount-volume",
-		},
-	}, nil, nil, "")
+// Save builds a new image whose only layer holds the content of the volume selected by the filter in the /volume-data directory.
+// The image has no other file but the DataIndexFile: it is not meant to be run, its content is read by Load.
+// If base is not empty, the new image is made of the layers of the base image, which must have been saved by Save, and of a layer
+// that only holds the changes of the volume since then, so that a registry stores and transfers the unchanged layers once.
+// The metadata of the volume is stored in the MetadataLabel label of the image, and its provenance in the labels listed in Provenance.
+// The content of the volume must not change while it is saved, i.e. the containers using it must be stopped.
+func Save(ctx context.Context, client *client.Client, volumeName, image, base string, filter Filter) (Provenance, error) {
+	metadata, err := GetVolumeMetadata(ctx, client, volumeName)
 	if err != nil {
-		return err
+		return Provenance{}, err
+	}
+	info, err := client.Info(ctx)
+	if err != nil {
+		return Provenance{}, err
 	}
 
-	if err := client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return err
+	var baseImage types.ImageInspect
+	var baseIndex DataIndex
+	if base != "" {
+		baseImage, _, err = client.ImageInspectWithRaw(ctx, base)
+		if err != nil {
+			return Provenance{}, err
+		}
+		baseIndex, err = ReadDataIndex(ctx, client, volumeName, base)
+		if err != nil {
+			return Provenance{}, err
+		}
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := client.ImagePull(ctx, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return Provenance{}, err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return Provenance{}, err
 	}
 
-	var exitCode int64
-	statusCh, errCh := client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	provenance := Provenance{
+		Volume:  volumeName,
+		Host:    info.Name,
+		Driver:  metadata.Driver,
+		Labels:  metadata.Labels,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Version: internal.Version(),
+		Base:    baseImage.ID,
+	}
+	// labels completes the provenance with the layer of the volume and returns the labels of the image
+	labels := func(layer dataLayer) map[string]string {
+		provenance.Size = layer.size
+		provenance.Files = layer.files
+		provenance.ContentDigest = layer.digest.String()
+
+		labels := provenance.ImageLabels()
+		labels[MetadataLabel] = metadata.String()
+		labels[DataImageLabel] = "true"
+		return labels
+	}
+
+	if base != "" {
+		f, layer, err := buildLayerFile(ctx, client, volumeName, image, filter, baseIndex)
+		if f != nil {
+			defer func() {
+				_ = f.Close()
+				_ = os.Remove(f.Name())
+			}()
+		}
 		if err != nil {
-			return err
+			return Provenance{}, err
 		}
-	case status := <-statusCh:
-		log.Infof("status: %#+v\n", status)
-		exitCode = status.StatusCode
+		log.Infof("layer of the changes of volume %s since image %s: %d bytes", volumeName, base, layer.written)
+
+		if err := loadLayeredImage(ctx, client, baseImage.ID, f, layer, labels(layer), image, provenance.Created); err != nil {
+			return Provenance{}, err
+		}
+		return provenance, nil
 	}
 
-	out, err := client.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true})
+	// The labels of an image are set when its layer is imported, so the volume is read a first time to compute
+	// the digest of the layer, without reporting to the progress, and a second time to import the layer.
+	layer, err := streamDataLayer(WithProgress(ctx, nil), client, volumeName, image, filter, nil, nil, io.Discard)
 	if err != nil {
+		return Provenance{}, err
+	}
+	contentDigest := layer.digest
+
+	var changes []string
+	for key, value := range labels(layer) {
+		changes = append(changes, labelChange(key, value))
+	}
+	sort.Strings(changes)
+
+	// The layer is imported while it is built, so the content of the volume is written to the disk only once
+	layerReader, layerWriter := io.Pipe()
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		layer, err = streamDataLayer(gCtx, client, volumeName, image, filter, nil, nil, layerWriter)
+
+		// A nil error closes the pipe with io.EOF
+		_ = layerWriter.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		resp, err := client.ImageImport(gCtx, types.ImageImportSource{
+			Source:     layerReader,
+			SourceName: "-",
+		}, image, types.ImageImportOptions{
+			Changes:  changes,
+			Platform: "linux/" + runtime.GOARCH,
+		})
+		if err == nil {
+			// The errors that occur while the layer is imported are part of the response
+			err = jsonmessage.DisplayJSONMessagesStream(resp, os.Stdout, 0, false, nil)
+			_ = resp.Close()
+		}
+
+		// Unblock the building of the layer if it can't be imported
+		_ = layerReader.CloseWithError(err)
 		return err
+	})
+	if err := g.Wait(); err != nil {
+		return Provenance{}, err
+	}
+
+	if layer.digest != contentDigest {
+		if _, err := client.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true}); err != nil {
+			log.Error(err)
+		}
+		return Provenance{}, fmt.Errorf("the content of volume %s changed while it was saved", volumeName)
 	}
 
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, out)
+	return provenance, nil
+}
+
+// buildLayerFile writes into a temporary file the layer that holds the changes of the volume since the image with the base index was saved.
+// The layer is written to a file since its size and digest must be known before the image is loaded. The file must be removed by the caller.
+func buildLayerFile(ctx context.Context, client *client.Client, volumeName, image string, filter Filter, baseIndex DataIndex) (*os.File, dataLayer, error) {
+	f, err := os.CreateTemp("", "vackup-layer-*.tar")
+	if err != nil {
+		return nil, dataLayer{}, err
+	}
+
+	var layer dataLayer
+	var forced map[string]bool
+	for {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return f, dataLayer{}, err
+		}
+		if err := f.Truncate(0); err != nil {
+			return f, dataLayer{}, err
+		}
+		w := &countingWriter{w: f}
+		layer, err = streamDataLayer(ctx, client, volumeName, image, filter, baseIndex, forced, w)
+		if err != nil {
+			return f, dataLayer{}, err
+		}
+		layer.written = w.n
+		if len(layer.missing) == 0 {
+			break
+		}
+		if forced != nil {
+			return f, dataLayer{}, fmt.Errorf("the content of volume %s changed while it was saved", volumeName)
+		}
+
+		// The unchanged targets of new hard links are written again with their links
+		forced = map[string]bool{}
+		for _, name := range layer.missing {
+			forced[name] = true
+		}
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	return f, layer, err
+}
+
+// loadLayeredImage loads as image the image made of the layers of the base image and of the layer read from r, with the labels.
+// An image can't be imported on top of another one, so the "docker save" archive of the base image is extended with the layer and loaded.
+func loadLayeredImage(ctx context.Context, client *client.Client, baseID string, r io.Reader, layer dataLayer, labels map[string]string, image string, created time.Time) error {
+	// "docker load" only accepts tagged references
+	ref, err := reference.ParseNormalizedNamed(image)
 	if err != nil {
 		return err
 	}
+	tagged, ok := reference.TagNameOnly(ref).(reference.NamedTagged)
+	if !ok {
+		return fmt.Errorf("invalid image name %s: the image can't be tagged", image)
+	}
 
-	if exitCode != 0 {
-		return fmt.Errorf("container exited with status code %d\n", exitCode)
+	saved, err := client.ImageSave(ctx, []string{baseID})
+	if err != nil {
+		return err
 	}
+	defer saved.Close()
+
+	// An image can't be imported on top of another one, so the archive of a new image made of all the layers is loaded instead
+	archiveReader, archiveWriter := io.Pipe()
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := writeLayeredImage(archiveWriter, saved, baseID, r, layer.written, layer.digest, labels, tagged.String(), created)
 
-	_, err = client.ContainerCommit(ctx, resp.ID, types.ContainerCommitOptions{
-		Reference: image,
+		// A nil error closes the pipe with io.EOF
+		_ = archiveWriter.CloseWithError(err)
+		return err
 	})
+	g.Go(func() error {
+		resp, err := client.ImageLoad(gCtx, archiveReader, true)
+		if err == nil {
+			// The errors that occur while the image is loaded are part of the response
+			err = jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stdout, 0, false, nil)
+			_ = resp.Body.Close()
+		}
 
-	err = client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
-	if err != nil {
+		// Unblock the writing of the archive if it can't be loaded
+		_ = archiveReader.CloseWithError(err)
 		return err
+	})
+
+	return g.Wait()
+}
+
+// dataLayer describes the layer of a data image built by streamDataLayer.
+type dataLayer struct {
+	// digest is the digest of the uncompressed layer.
+	digest digest.Digest
+	// files and size are the number and the total size of the regular files of the volume.
+	files, size int64
+	// written is the size of the uncompressed layer, when it is known.
+	written int64
+	// missing are the targets of the hard links left out of the layer, see writeDataLayer.
+	missing []string
+}
+
+// streamDataLayer reads the content of the volume selected by the filter with a helper container, and writes into w
+// the uncompressed layer of an image that holds it in the /volume-data directory, see writeDataLayer.
+func streamDataLayer(ctx context.Context, client *client.Client, volumeName, image string, filter Filter, base DataIndex, forced map[string]bool, w io.Writer) (dataLayer, error) {
+	// The listing of the archived files is printed on stderr, see Progress
+	cmd, ok := filter.TarCmd("/mount-volume", append([]string{"-cf", "-"}, ProgressTarArgs...)...)
+	if !ok {
+		cmd = ShellCmd(filter.TarScript("/mount-volume", ProgressTarOpts, "-cf", "-"), filter.Args()...)
 	}
-	return err
+	log.Infof("cmd: %v", cmd)
+
+	archiveReader, archiveWriter := io.Pipe()
+	var layer dataLayer
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := StreamFromContainer(gCtx, client, &container.Config{
+			Image: internal.AlpineTarZstdImage,
+			Cmd:   cmd,
+			Env:   filter.Env(),
+			User:  "root",
+			Labels: map[string]string{
+				"com.docker.desktop.extension":        "true",
+				"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+				"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+				"com.volumes-backup-extension.action": "save",
+				"com.volumes-backup-extension.image":  image,
+				"com.volumes-backup-extension.volume": volumeName,
+			},
+		}, &container.HostConfig{
+			Binds: []string{
+				volumeName + ":" + "/mount-volume:ro",
+			},
+		}, archiveWriter)
+
+		// A nil error closes the pipe with io.EOF
+		_ = archiveWriter.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		// The members of the archive of the volume are moved under /volume-data
+		digester := digest.SHA256.Digester()
+		tw := tar.NewWriter(io.MultiWriter(w, digester.Hash()))
+		var err error
+		layer, err = writeDataLayer(tw, archiveReader, base, forced)
+		if err == nil {
+			err = tw.Close()
+		}
+		layer.digest = digester.Digest()
+
+		// Stop reading the volume if the layer can't be written
+		_ = archiveReader.CloseWithError(err)
+		return err
+	})
+
+	return layer, g.Wait()
+}
+
+// labelChange returns the Dockerfile instruction that sets the label of an image to the value, e.g. `LABEL key="value"`.
+// The value is quoted so that the instruction is parsed as a single label, and it is never expanded.
+func labelChange(key, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value)
+	return "LABEL " + key + `="` + value + `"`
 }
@@ -0,0 +1,15 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelChange(t *testing.T) {
+	metadata := VolumeMetadata{Driver: "local", Labels: map[string]string{"path": `C:\data`, "cost": "$HOME \"quoted\""}}
+
+	require.Equal(t,
+		`LABEL com.volumes-backup-extension.volume-metadata="{\"driver\":\"local\",\"labels\":{\"cost\":\"\$HOME \\\"quoted\\\"\",\"path\":\"C:\\\\data\"}}"`,
+		labelChange(MetadataLabel, metadata.String()))
+}
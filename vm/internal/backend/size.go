@@ -13,6 +13,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	volumetypes "github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/log"
@@ -38,9 +39,30 @@ func GetVolumesSize(ctx context.Context, cli *client.Client, volumeName string)
 		return m, err
 	}
 
+	// The size of every volume is computed when volumeName is "*".
+	// du is given the directory of every volume, without a shell to expand a glob.
+	volumeNames := []string{volumeName}
+	if volumeName == "*" {
+		volumes, err := cli.VolumeList(ctx, volumetypes.ListOptions{})
+		if err != nil {
+			return m, err
+		}
+		volumeNames = volumeNames[:0]
+		for _, v := range volumes.Volumes {
+			volumeNames = append(volumeNames, v.Name)
+		}
+		if len(volumeNames) == 0 {
+			return m, nil
+		}
+	}
+	cmd := []string{"du", "-d", "0", "--"}
+	for _, name := range volumeNames {
+		cmd = append(cmd, "/var/lib/docker/volumes/"+name)
+	}
+
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
 		Tty:   true,
-		Cmd:   []string{"/bin/sh", "-c", "du -d 0 /var/lib/docker/volumes/" + volumeName},
+		Cmd:   cmd,
 		Image: internal.NsenterImage,
 		Labels: map[string]string{
 			"com.docker.desktop.extension":        "true",
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// SnapshotLabel is the label of the safety snapshot volumes. Its value is the name of the volume the snapshot was taken from.
+const SnapshotLabel = "com.volumes-backup-extension.snapshot-of"
+
+// CopyVolume copies the content of the volume "from" into the existing volume "to", preserving ownership and permissions.
+// The content of "to" is removed first if the strategy is ImportStrategyReplace, and its files are never overwritten
+// if the strategy is ImportStrategySkipExisting.
+func CopyVolume(ctx context.Context, cli *client.Client, from, to string, strategy ImportStrategy) error {
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctx, internal.BusyboxImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The files are copied with a tar pipe instead of "cp" for the listing of the copied files to be printed on stderr, see Progress
+	cmd := fmt.Sprintf("%scd /from && tar -cf - . | tar %s -xvvpf - -C /to >&2", strategy.RemoveCmd("/to"), strings.Join(strategy.BusyboxTarOpts(), " "))
+	log.Infof("cmd: %s", cmd)
+
+	return StreamFromContainer(ctx, cli, &container.Config{
+		Image: internal.BusyboxImage,
+		Cmd:   ShellCmd(cmd),
+		User:  "root",
+		Labels: map[string]string{
+			"com.docker.desktop.extension":                    "true",
+			"com.docker.desktop.extension.name":               "Volumes Backup & Share",
+			"com.docker.compose.project":                      "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action":             "clone",
+			"com.volumes-backup-extension.volume":             from,
+			"com.volumes-backup-extension.destination-volume": to,
+		},
+	}, &container.HostConfig{
+		Binds: []string{
+			from + ":" + "/from",
+			to + ":" + "/to",
+		},
+	}, os.Stdout)
+}
+
+// CreateSnapshot copies the content of the volume into a new volume labeled with SnapshotLabel and returns the name of the new volume.
+// The containers using the volume must be stopped.
+func CreateSnapshot(ctx context.Context, cli *client.Client, volumeName string) (string, error) {
+	snapshot := fmt.Sprintf("%s-snapshot-%d", volumeName, time.Now().UnixNano())
+
+	_, err := cli.VolumeCreate(ctx, volumetypes.CreateOptions{
+		Name: snapshot,
+		Labels: map[string]string{
+			SnapshotLabel: volumeName,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The snapshot is not part of the progress of the action that takes it
+	if err := CopyVolume(WithProgress(ctx, nil), cli, volumeName, snapshot, ImportStrategyMerge); err != nil {
+		_ = cli.VolumeRemove(context.Background(), snapshot, true)
+		return "", err
+	}
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot replaces the content of the volume with the content of the snapshot and removes the snapshot.
+func RestoreSnapshot(ctx context.Context, cli *client.Client, snapshot, volumeName string) error {
+	if err := CopyVolume(ctx, cli, snapshot, volumeName, ImportStrategyReplace); err != nil {
+		return err
+	}
+
+	return cli.VolumeRemove(ctx, snapshot, true)
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// StagingLabel is the label of the staging volumes. Its value is the name of the volume the staged content is imported into.
+const StagingLabel = "com.volumes-backup-extension.staging-of"
+
+// CreateStagingVolume creates an empty volume labeled with StagingLabel and returns its name.
+// An archive is extracted into the staging volume first, and copied into the volume only if it could be extracted entirely.
+func CreateStagingVolume(ctx context.Context, cli *client.Client, volumeName string) (string, error) {
+	staging := fmt.Sprintf("%s-staging-%d", volumeName, time.Now().UnixNano())
+
+	_, err := cli.VolumeCreate(ctx, volumetypes.CreateOptions{
+		Name: staging,
+		Labels: map[string]string{
+			StagingLabel: volumeName,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return staging, nil
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"fmt"
+)
+
+// ImportStrategy defines what happens to the current content of a volume when an archive or an image is imported into it.
+type ImportStrategy string
+
+const (
+	// ImportStrategyReplace removes the content of the volume before importing. It is the default strategy.
+	ImportStrategyReplace ImportStrategy = "replace"
+	// ImportStrategyMerge keeps the content of the volume and overwrites only the files that are present in the archive.
+	ImportStrategyMerge ImportStrategy = "merge"
+	// ImportStrategySkipExisting keeps the content of the volume and never overwrites a file that already exists.
+	ImportStrategySkipExisting ImportStrategy = "skip-existing"
+)
+
+// ParseImportStrategy returns the import strategy with the given name, or ImportStrategyReplace if the name is empty.
+func ParseImportStrategy(name string) (ImportStrategy, error) {
+	switch s := ImportStrategy(name); s {
+	case "":
+		return ImportStrategyReplace, nil
+	case ImportStrategyReplace, ImportStrategyMerge, ImportStrategySkipExisting:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unsupported strategy %q, it must be one of %s, %s or %s", name, ImportStrategyReplace, ImportStrategyMerge, ImportStrategySkipExisting)
+	}
+}
+
+// RemoveCmd returns the shell command, followed by "&&", that removes the content of the directory dir before importing,
+// or an empty string if the strategy keeps the content of the volume.
+func (s ImportStrategy) RemoveCmd(dir string) string {
+	if s != ImportStrategyReplace {
+		return ""
+	}
+
+	// remove hidden and not-hidden files and folders:
+	// ..?* matches all dot-dot files except '..'
+	// .[!.]* matches all dot files except '.' and files whose name begins with '..'
+	return fmt.Sprintf("rm -rf %[1]s/..?* %[1]s/.[!.]* %[1]s/* && ", dir)
+}
+
+// TarOpts returns the options of GNU tar that implement the strategy when extracting an archive, if any.
+// tar overwrites existing files by default.
+func (s ImportStrategy) TarOpts() []string {
+	if s == ImportStrategySkipExisting {
+		return []string{"--skip-old-files"}
+	}
+	return nil
+}
+
+// BusyboxTarOpts returns the options of busybox tar that implement the strategy when extracting an archive, if any.
+// It is used to copy the content of a volume into another volume.
+func (s ImportStrategy) BusyboxTarOpts() []string {
+	if s == ImportStrategySkipExisting {
+		// "-k" to not overwrite an existing file
+		return []string{"-k"}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportStrategy(t *testing.T) {
+	strategy, err := ParseImportStrategy("")
+	require.NoError(t, err)
+	require.Equal(t, ImportStrategyReplace, strategy)
+
+	strategy, err = ParseImportStrategy("skip-existing")
+	require.NoError(t, err)
+	require.Equal(t, ImportStrategySkipExisting, strategy)
+
+	_, err = ParseImportStrategy("overwrite")
+	require.Error(t, err)
+}
+
+func TestImportStrategyCommands(t *testing.T) {
+	require.Equal(t, "rm -rf /v/..?* /v/.[!.]* /v/* && ", ImportStrategyReplace.RemoveCmd("/v"))
+	require.Empty(t, ImportStrategyMerge.RemoveCmd("/v"))
+	require.Empty(t, ImportStrategySkipExisting.RemoveCmd("/v"))
+
+	require.Empty(t, ImportStrategyMerge.TarOpts())
+	require.Equal(t, []string{"--skip-old-files"}, ImportStrategySkipExisting.TarOpts())
+
+	require.Empty(t, ImportStrategyMerge.BusyboxTarOpts())
+	require.Equal(t, []string{"-k"}, ImportStrategySkipExisting.BusyboxTarOpts())
+}
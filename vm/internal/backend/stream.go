@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// ExitError is returned when a container exits with a non-zero status code.
+type ExitError struct {
+	StatusCode int64
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with status code %d\n", e.StatusCode)
+}
+
+// StreamFromContainer creates and starts a container and copies its stdout into w while it is running.
+// The stderr of the container is written to the standard error of the backend, and to the progress of the context, if any.
+// The container is removed once it has exited, and an error is returned if it exited with a non-zero status code.
+func StreamFromContainer(ctx context.Context, cli *client.Client, config *container.Config, hostConfig *container.HostConfig, w io.Writer) error {
+	config.AttachStdout = true
+	config.AttachStderr = true
+
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	return runContainer(ctx, cli, resp.ID, w)
+}
+
+// runContainer starts a created container, copies its stdout into w and its stderr into the writer returned by stderrWriter
+// while it is running, and returns an ExitError if it exited with a non-zero status code. The container is not removed.
+func runContainer(ctx context.Context, cli *client.Client, containerID string, w io.Writer) error {
+	// Attach before starting the container so that no output is lost
+	hijacked, err := cli.ContainerAttach(ctx, containerID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer hijacked.Close()
+
+	if err := cli.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+
+	if _, err := stdcopy.StdCopy(w, stderrWriter(ctx), hijacked.Reader); err != nil {
+		return err
+	}
+
+	var exitCode int64
+	statusCh, errCh := cli.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case status := <-statusCh:
+		log.Infof("status: %#+v\n", status)
+		exitCode = status.StatusCode
+	}
+
+	if exitCode != 0 {
+		return &ExitError{StatusCode: exitCode}
+	}
+
+	return nil
+}
+
+// StreamIntoContainer creates and starts a container and copies r into its stdin while it is running.
+// The stdin of the container is closed once r is drained, so the command running in the container receives an EOF.
+// The stderr of the container is written to the standard error of the backend, and to the progress of the context, if any.
+// The container is removed once it has exited, and an error is returned if it exited with a non-zero status code.
+func StreamIntoContainer(ctx context.Context, cli *client.Client, config *container.Config, hostConfig *container.HostConfig, r io.Reader) error {
+	config.AttachStdin = true
+	config.AttachStdout = true
+	config.AttachStderr = true
+	config.OpenStdin = true
+	config.StdinOnce = true
+
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	// Attach before starting the container so that no input is lost
+	hijacked, err := cli.ContainerAttach(ctx, resp.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer hijacked.Close()
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(os.Stdout, stderrWriter(ctx), hijacked.Reader)
+		outputDone <- err
+	}()
+
+	_, copyErr := io.Copy(hijacked.Conn, r)
+	if copyErr == nil {
+		copyErr = hijacked.CloseWrite()
+	}
+	if copyErr != nil {
+		// The command might have exited early, e.g. on a corrupt archive, which breaks the pipe:
+		// its exit status tells what happened better than the error of the copy
+		hijacked.Close()
+	}
+
+	outputErr := <-outputDone
+
+	var exitCode int64
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case status := <-statusCh:
+		log.Infof("status: %#+v\n", status)
+		exitCode = status.StatusCode
+	}
+
+	if exitCode != 0 {
+		return &ExitError{StatusCode: exitCode}
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	return outputErr
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// volumeNameRegexp is the format of the names of the volumes accepted by the Docker Engine.
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// windowsDriveRegexp matches the beginning of an absolute Windows path, e.g. "C:\".
+var windowsDriveRegexp = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
+
+// ValidateVolumeName returns an error if name is not a valid volume name.
+func ValidateVolumeName(name string) error {
+	if !volumeNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// ValidateFileName returns an error if name is not the name of a file, e.g. it contains a path separator.
+func ValidateFileName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid file name %q", name)
+	case len(name) > 255:
+		return fmt.Errorf("invalid file name %q, it must be at most 255 bytes long", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("invalid file name %q, it must not contain a path separator", name)
+	case strings.HasPrefix(name, "-"):
+		// A leading dash would be taken for an option by the commands that receive the name
+		return fmt.Errorf("invalid file name %q, it must not start with a dash", name)
+	case hasControlChar(name):
+		return fmt.Errorf("invalid file name %q, it must not contain control characters", name)
+	}
+	return nil
+}
+
+// ValidateHostPath returns an error if p is not an absolute path of the host, either a Unix or a Windows one.
+// Relative elements ("..") are rejected, and so are colons outside of a Windows drive, as they separate the fields of a bind mount.
+func ValidateHostPath(p string) error {
+	rest := p
+	switch {
+	case strings.HasPrefix(p, "/"), strings.HasPrefix(p, `\\`):
+	case windowsDriveRegexp.MatchString(p):
+		rest = p[2:]
+	default:
+		return fmt.Errorf("invalid path %q, it must be absolute", p)
+	}
+
+	if hasControlChar(p) {
+		return fmt.Errorf("invalid path %q, it must not contain control characters", p)
+	}
+	if strings.Contains(rest, ":") {
+		return fmt.Errorf("invalid path %q, it must not contain a colon", p)
+	}
+	for _, element := range strings.FieldsFunc(rest, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return fmt.Errorf("invalid path %q, it must not contain %q", p, element)
+		}
+	}
+	return nil
+}
+
+// hasControlChar reports whether s contains a control character, e.g. a newline or a NUL byte.
+func hasControlChar(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) != -1
+}
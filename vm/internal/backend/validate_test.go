@@ -0,0 +1,34 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVolumeName(t *testing.T) {
+	for _, name := range []string{"db", "my-volume_1.0", "5d7f9b1c3e5a7c9e"} {
+		require.NoError(t, ValidateVolumeName(name), name)
+	}
+	for _, name := range []string{"", "a", "-rf", ".hidden", "a b", "a;reboot", "$(reboot)", "`reboot`", "a\nb", "../etc", "a/b", "a:/etc"} {
+		require.Error(t, ValidateVolumeName(name), name)
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	for _, name := range []string{"backup.tar.zst", "my volume (1).tar", "it's.tar", "$(reboot).tar"} {
+		require.NoError(t, ValidateFileName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "../backup.tar", "a/b.tar", `a\b.tar`, "-rf", "a\nb.tar", "a\x00b.tar", string(make([]byte, 256))} {
+		require.Error(t, ValidateFileName(name), name)
+	}
+}
+
+func TestValidateHostPath(t *testing.T) {
+	for _, p := range []string{"/tmp", "/Users/me/My Backups", `C:\Users\me`, "C:/Users/me", `\\server\share`, "/tmp/$(reboot)"} {
+		require.NoError(t, ValidateHostPath(p), p)
+	}
+	for _, p := range []string{"", "tmp", "./tmp", "/tmp/../etc", `C:\Users\..\Windows`, "/tmp:/etc", "/tmp:ro", "/tmp\nb", "-rf", "C:"} {
+		require.Error(t, ValidateHostPath(p), p)
+	}
+}
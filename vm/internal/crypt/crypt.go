@@ -0,0 +1,255 @@
+// Package crypt implements the streaming encryption format used for encrypted volume archives.
+//
+// An encrypted archive starts with a header made of the magic string "VACKENC1", a random 16-byte salt
+// and a random 7-byte nonce prefix. The key is derived from a passphrase (or the content of a key file) with scrypt.
+// The plaintext follows the header, split into chunks of 64 KiB that are sealed with AES-256-GCM.
+// The nonce of every chunk is made of the nonce prefix, a 4-byte big-endian counter and a byte set to 1 for the last chunk only,
+// so that reordered, duplicated or truncated chunks are detected. The header is authenticated as additional data of every chunk.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Magic is the string every encrypted archive starts with.
+const Magic = "VACKENC1"
+
+const (
+	saltSize        = 16
+	noncePrefixSize = 7
+	headerSize      = len(Magic) + saltSize + noncePrefixSize
+	chunkSize       = 64 * 1024
+	tagSize         = 16
+	sealedChunkSize = chunkSize + tagSize
+	maxChunks       = 1<<32 - 1
+)
+
+var (
+	// ErrWrongKey is returned when the first chunk of an archive cannot be decrypted, which means the passphrase or key file is wrong.
+	ErrWrongKey = errors.New("wrong passphrase or key file")
+	// ErrCorrupted is returned when a chunk other than the first one cannot be decrypted, or the archive is truncated.
+	ErrCorrupted = errors.New("encrypted archive is corrupted or truncated")
+	// ErrNotEncrypted is returned when the archive does not start with the magic string.
+	ErrNotEncrypted = errors.New("archive is not encrypted")
+	// ErrTooLarge is returned when the plaintext exceeds the maximum number of chunks supported by the format (256 TiB).
+	ErrTooLarge = errors.New("archive is too large to be encrypted")
+)
+
+// IsEncrypted reports whether the first bytes of an archive are the ones of an encrypted archive.
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(Magic))
+}
+
+func deriveAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, noncePrefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[noncePrefixSize:], counter)
+	if last {
+		n[noncePrefixSize+4] = 1
+	}
+	return n
+}
+
+// Writer encrypts the data written to it. Close must be called to write the last chunk.
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewWriter writes the header of an encrypted archive to w and returns a Writer that encrypts the data with a key derived from secret.
+func NewWriter(w io.Writer, secret []byte) (*Writer, error) {
+	header := make([]byte, headerSize)
+	copy(header, Magic)
+	if _, err := io.ReadFull(rand.Reader, header[len(Magic):]); err != nil {
+		return nil, err
+	}
+
+	aead, err := deriveAEAD(secret, header[len(Magic):len(Magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[len(Magic)+saltSize:],
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed crypt.Writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so that the last chunk is empty only if there is no data at all
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		c := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals and writes the last chunk. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.flush(true)
+}
+
+func (w *Writer) flush(last bool) error {
+	if w.counter == maxChunks {
+		return ErrTooLarge
+	}
+
+	sealed := w.aead.Seal(nil, nonce(w.prefix, w.counter, last), w.buf, w.header)
+	w.counter++
+	w.buf = w.buf[:0]
+
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Reader decrypts an encrypted archive.
+type Reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	last    bool
+	err     error
+}
+
+// NewReader reads the header and decrypts the first chunk of an encrypted archive.
+// It returns ErrWrongKey without consuming the rest of r if the first chunk cannot be decrypted with a key derived from secret,
+// so that callers can fail before doing anything destructive with the plaintext.
+func NewReader(r io.Reader, secret []byte) (*Reader, error) {
+	br := bufio.NewReaderSize(r, sealedChunkSize+1)
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if !IsEncrypted(header) {
+		return nil, ErrNotEncrypted
+	}
+
+	aead, err := deriveAEAD(secret, header[len(Magic):len(Magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &Reader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		prefix: header[len(Magic)+saltSize:],
+	}
+
+	if err := cr.next(); err != nil {
+		if err == ErrCorrupted {
+			return nil, ErrWrongKey
+		}
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.last {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk.
+func (r *Reader) next() error {
+	sealed := make([]byte, sealedChunkSize)
+	n, err := io.ReadFull(r.r, sealed)
+	switch {
+	case err == io.EOF:
+		// The last chunk, which always exists, was never found
+		return ErrCorrupted
+	case err == io.ErrUnexpectedEOF:
+		r.last = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one only if nothing follows it
+		if _, err := r.r.Peek(1); err == io.EOF {
+			r.last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := r.aead.Open(nil, nonce(r.prefix, r.counter, r.last), sealed[:n], r.header)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.counter++
+	r.buf = plaintext
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, plaintext, secret []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, secret)
+	require.NoError(t, err)
+	_, err = w.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 42} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		ciphertext := encrypt(t, plaintext, secret)
+		require.True(t, IsEncrypted(ciphertext))
+
+		r, err := NewReader(bytes.NewReader(ciphertext), secret)
+		require.NoError(t, err, "size %d", size)
+		decrypted, err := io.ReadAll(r)
+		require.NoError(t, err, "size %d", size)
+		require.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	ciphertext := encrypt(t, []byte("some data"), []byte("right"))
+
+	_, err := NewReader(bytes.NewReader(ciphertext), []byte("wrong"))
+	require.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestNotEncrypted(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("this is a plain archive, not an encrypted one")), []byte("secret"))
+	require.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestTruncated(t *testing.T) {
+	secret := []byte("secret")
+	plaintext := make([]byte, 2*chunkSize+10)
+	ciphertext := encrypt(t, plaintext, secret)
+
+	// Drop the last chunk: the previous chunk was not sealed as the last one
+	truncated := ciphertext[:headerSize+2*sealedChunkSize]
+
+	r, err := NewReader(bytes.NewReader(truncated), secret)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestTampered(t *testing.T) {
+	secret := []byte("secret")
+	plaintext := make([]byte, 2*chunkSize+10)
+	ciphertext := encrypt(t, plaintext, secret)
+	ciphertext[headerSize+sealedChunkSize+5] ^= 0xff
+
+	r, err := NewReader(bytes.NewReader(ciphertext), secret)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrCorrupted)
+}
@@ -0,0 +1,438 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// ExportBundle exports the volumes given with the "volume" query parameter into a single bundle written to the host directory "path" as "fileName".
+// The bundle is an uncompressed tar archive with a manifest.json file that describes the volumes (name, driver, driver options, labels and size)
+// and the archive of every volume, compressed with "compression" (zstd by default) at the level "level".
+// The containers using the volumes are stopped during the export.
+func (h *Handler) ExportBundle(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	volumeNames := ctx.QueryParams()["volume"]
+	path := ctx.QueryParam("path")
+	fileName := ctx.QueryParam("fileName")
+	compressionName := ctx.QueryParam("compression")
+	levelParam := ctx.QueryParam("level")
+
+	if len(volumeNames) == 0 {
+		return ctx.String(http.StatusBadRequest, "at least one volume is required")
+	}
+	seen := make(map[string]bool, len(volumeNames))
+	for _, volumeName := range volumeNames {
+		if volumeName == "" || seen[volumeName] {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid or duplicate volume %q", volumeName))
+		}
+		if err := backend.ValidateVolumeName(volumeName); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		seen[volumeName] = true
+	}
+	if path == "" {
+		return ctx.String(http.StatusBadRequest, "path is required")
+	}
+	if err := backend.ValidateHostPath(path); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if fileName == "" {
+		return ctx.String(http.StatusBadRequest, "fileName is required")
+	}
+	if err := backend.ValidateFileName(fileName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if compressionName == "" {
+		compressionName = "zstd"
+	}
+	compression, ok := backend.CompressionByName(compressionName)
+	if !ok {
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("unsupported compression %q", compressionName))
+	}
+	level := backend.UnsetLevel
+	if levelParam != "" {
+		l, err := strconv.Atoi(levelParam)
+		if err != nil || l < 0 {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid level %q", levelParam))
+		}
+		level = l
+	}
+	compressProgram, err := compression.CompressProgram(level)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	log.Infof("volumeNames: %+v", volumeNames)
+	log.Infof("path: %s", path)
+	log.Infof("fileName: %s", fileName)
+	log.Infof("compression: %s", compression.Name)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	manifest := backend.BundleManifest{Version: backend.BundleVersion}
+	for _, volumeName := range volumeNames {
+		v, err := cli.VolumeInspect(ctxReq, volumeName)
+		if client.IsErrNotFound(err) {
+			return ctx.String(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		manifest.Volumes = append(manifest.Volumes, backend.BundleVolume{
+			Name:       v.Name,
+			Driver:     v.Driver,
+			DriverOpts: v.Options,
+			Labels:     v.Labels,
+			Archive:    backend.BundleArchive(v.Name, compression),
+		})
+	}
+
+	sizes, err := backend.GetVolumesSize(ctxReq, cli, "*")
+	if err != nil {
+		return err
+	}
+	for i, v := range manifest.Volumes {
+		manifest.Volumes[i].Size = sizes[v.Name].Bytes
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		h.ProgressCache.Lock()
+		for _, volumeName := range volumeNames {
+			delete(h.ProgressCache.m, volumeName)
+		}
+		h.ProgressCache.Unlock()
+		_ = backend.TriggerUIRefresh(ctxReq, cli)
+	}()
+
+	h.ProgressCache.Lock()
+	for _, volumeName := range volumeNames {
+		h.ProgressCache.m[volumeName] = backend.NewProgress("export", 0)
+	}
+	h.ProgressCache.Unlock()
+
+	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The helper image might lack the compression program, which must fail the export before the containers are stopped
+	if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, compressProgram); err != nil {
+		return requireHelperCommand(ctx, err)
+	}
+
+	// Stop container(s)
+	var stoppedContainers []string
+	for _, volumeName := range volumeNames {
+		stopped, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
+		if err != nil {
+			// The containers of the volumes stopped before are started again
+			if err := backend.StartContainersByName(context.Background(), cli, stoppedContainers); err != nil {
+				log.Error(err)
+			}
+			return err
+		}
+		stoppedContainers = append(stoppedContainers, stopped...)
+	}
+
+	// The bundle starts with the manifest, then the archive of every volume is written to /tmp and appended to the bundle one at a time,
+	// so that the container never holds more than one archive.
+	// The mount path and the archive of every volume are passed to the script as pairs of positional parameters.
+	var tarOpts []string
+	if compressProgram != "" {
+		tarOpts = append(tarOpts, "-I", "\""+compressProgram+"\"")
+	}
+	tarOpts = append(tarOpts, "-cf", `"/tmp/$2"`)
+	script := fmt.Sprintf(`mkdir -p /tmp/%s && cd /tmp && printf '%%s' "$VACKUP_BUNDLE_MANIFEST" > %s && tar -cf "$VACKUP_BUNDLE" %s`,
+		backend.BundleVolumesDir, backend.BundleManifestFile, backend.BundleManifestFile)
+	script += fmt.Sprintf(` && while [ $# -gt 0 ]; do (%s) && tar -rf "$VACKUP_BUNDLE" -C /tmp -- "$2" && rm -- "/tmp/$2" || exit 1; shift 2; done`,
+		backend.Filter{}.TarScript(`"$1"`, tarOpts...))
+	var args []string
+	binds := []string{path + ":" + "/vackup"}
+	for i, v := range manifest.Volumes {
+		args = append(args, bundleMountPath(i), v.Archive)
+		binds = append(binds, v.Name+":"+bundleMountPath(i)+":ro")
+	}
+	log.Infof("script: %s", script)
+	log.Infof("binds: %+v", binds)
+
+	streamErr := backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(script, args...),
+		Env: []string{
+			"VACKUP_BUNDLE=" + "/vackup/" + filepath.Base(fileName),
+			"VACKUP_BUNDLE_MANIFEST=" + string(manifestJSON),
+		},
+		User: "root",
+		Labels: map[string]string{
+			"com.docker.desktop.extension":          "true",
+			"com.docker.desktop.extension.name":     "Volumes Backup & Share",
+			"com.docker.compose.project":            "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action":   "export-bundle",
+			"com.volumes-backup-extension.volume":   strings.Join(volumeNames, ","),
+			"com.volumes-backup-extension.path":     path,
+			"com.volumes-backup-extension.fileName": fileName,
+		},
+	}, &container.HostConfig{
+		Binds: binds,
+	}, os.Stdout)
+
+	// Start container(s) as soon as the volumes were read
+	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	var exitErr *backend.ExitError
+	if errors.As(streamErr, &exitErr) {
+		return ctx.String(http.StatusInternalServerError, exitErr.Error())
+	}
+	if streamErr != nil {
+		return streamErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, volumeName := range volumeNames {
+		h.trackBackup(backend.NewBackup(backend.BackupBundle, volumeName, filepath.Join(path, fileName), time.Now()))
+	}
+	return ctx.JSON(http.StatusCreated, manifest)
+}
+
+// ImportBundle imports the bundle located in the host at "path" and creates every volume it contains with its driver, the driver options that are safe to apply (see backend.SafeDriverOpts) and its labels.
+// Volumes can be renamed with the "rename" query parameter in the format "<name in the bundle>:<new name>".
+// The import fails with 409 Conflict if one of the volumes to create already exists, in which case no volume is created.
+// The response is the manifest of the bundle with the names of the created volumes.
+func (h *Handler) ImportBundle(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	path := ctx.QueryParam("path")
+	renameParams := ctx.QueryParams()["rename"]
+
+	if path == "" {
+		return ctx.String(http.StatusBadRequest, "path is required")
+	}
+	if err := backend.ValidateHostPath(path); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	renames := make(map[string]string, len(renameParams))
+	for _, rename := range renameParams {
+		i := strings.Index(rename, ":")
+		if i <= 0 || i == len(rename)-1 {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid rename %q, it must be in the format <name in the bundle>:<new name>", rename))
+		}
+		if err := backend.ValidateVolumeName(rename[i+1:]); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		renames[rename[:i]] = rename[i+1:]
+	}
+
+	log.Infof("path: %s", path)
+	log.Infof("renames: %+v", renames)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   []string{"tar", "-xOf", "/vackup", backend.BundleManifestFile},
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "read-bundle",
+			"com.volumes-backup-extension.path":   path,
+		},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup", ReadOnly: true},
+		},
+	}, &out)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "bundle manifest could not be read: "+strings.TrimSpace(exitErr.Error()))
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "bind source path does not exist") {
+			return ctx.String(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	manifest, err := backend.ParseBundleManifest(&out)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// Rename the volumes and check that none of them exists before creating any
+	inBundle := make(map[string]bool, len(manifest.Volumes))
+	created := make(map[string]bool, len(manifest.Volumes))
+	for i, v := range manifest.Volumes {
+		inBundle[v.Name] = true
+		if newName, ok := renames[v.Name]; ok {
+			manifest.Volumes[i].Name = newName
+		}
+		name := manifest.Volumes[i].Name
+		if created[name] {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("volume %q would be imported twice", name))
+		}
+		created[name] = true
+
+		_, err := cli.VolumeInspect(ctxReq, name)
+		if err == nil {
+			return ctx.String(http.StatusConflict, fmt.Sprintf("volume %q already exists", name))
+		}
+		if !client.IsErrNotFound(err) {
+			return err
+		}
+	}
+	for from := range renames {
+		if !inBundle[from] {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("volume %q is not in the bundle", from))
+		}
+	}
+
+	// The helper image might lack the decompression program of an archive, which must fail the import before any volume is created
+	for _, v := range manifest.Volumes {
+		if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, backend.CompressionByExt(filepath.Ext(v.Archive)).Program); err != nil {
+			return requireHelperCommand(ctx, err)
+		}
+	}
+
+	defer func() {
+		h.ProgressCache.Lock()
+		for _, v := range manifest.Volumes {
+			delete(h.ProgressCache.m, v.Name)
+		}
+		h.ProgressCache.Unlock()
+		_ = backend.TriggerUIRefresh(ctxReq, cli)
+	}()
+
+	h.ProgressCache.Lock()
+	for _, v := range manifest.Volumes {
+		h.ProgressCache.m[v.Name] = backend.NewProgress("import", 0)
+	}
+	h.ProgressCache.Unlock()
+
+	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+		return err
+	}
+
+	// The volumes created so far are removed if the import fails
+	var volumeNames []string
+	imported := false
+	defer func() {
+		if imported {
+			return
+		}
+		for _, volumeName := range volumeNames {
+			if err := cli.VolumeRemove(context.Background(), volumeName, true); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
+	// Every archive is read from the bundle and extracted into its volume without being written to the disk.
+	// The archive, the decompression program and the mount path of every volume are passed to the script as triples of positional parameters.
+	script := `set -o pipefail && while [ $# -gt 0 ]; do tar -xOf /vackup -- "$1" | tar ${2:+-I "$2"} -xvf - -C "$3" || exit 1; shift 3; done`
+	var args []string
+	binds := []string{path + ":" + "/vackup:ro"}
+	for i, v := range manifest.Volumes {
+		_, err := cli.VolumeCreate(ctxReq, volume.CreateOptions{
+			Name:       v.Name,
+			Driver:     v.Driver,
+			DriverOpts: backend.SafeDriverOpts(v.Driver, v.DriverOpts),
+			Labels:     v.Labels,
+		})
+		if err != nil {
+			return err
+		}
+		volumeNames = append(volumeNames, v.Name)
+
+		args = append(args, v.Archive, backend.CompressionByExt(filepath.Ext(v.Archive)).Program, bundleMountPath(i))
+		binds = append(binds, v.Name+":"+bundleMountPath(i))
+	}
+	log.Infof("script: %s", script)
+	log.Infof("binds: %+v", binds)
+
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(script, args...),
+		User:  "root",
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "import-bundle",
+			"com.volumes-backup-extension.volume": strings.Join(volumeNames, ","),
+			"com.volumes-backup-extension.path":   path,
+		},
+	}, &container.HostConfig{
+		Binds: binds,
+	}, os.Stdout)
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusInternalServerError, exitErr.Error())
+	}
+	if err != nil {
+		return err
+	}
+	imported = true
+
+	return ctx.JSON(http.StatusCreated, manifest)
+}
+
+// bundleMountPath returns the path where the i-th volume of a bundle is mounted in the container.
+func bundleMountPath(i int) string {
+	return fmt.Sprintf("/vackup-volumes/%03d", i+1)
+}
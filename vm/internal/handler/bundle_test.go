@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestExportAndImportBundle(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumes := map[string]string{
+		"2b4d6f8a0c1e3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c": "db",
+		"3c5e7a9b1d3f5b7d9f1a3c5e7b9d1f3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d": "cache",
+	}
+	renamed := "4d6f8b0c2e4a6c8e0a2c4e6b8d0f2a4c6e8b0d2f4a6c8e0b2d4f6a8c0e2b4d6f"
+	for volumeID, content := range volumes {
+		_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+			Driver: "local",
+			Name:   volumeID,
+			Labels: map[string]string{"app": "shop"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runInVolume(t, cli, volumeID, "echo "+content+" > /data/content.txt")
+	}
+	defer func() {
+		for volumeID := range volumes {
+			_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		}
+		_ = cli.VolumeRemove(context.Background(), renamed, true)
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	// Export bundle
+	e := echo.New()
+	q := make(url.Values)
+	for volumeID := range volumes {
+		q.Add("volume", volumeID)
+	}
+	q.Set("path", tmpDir)
+	q.Set("fileName", "shop.bundle.tar")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/bundles/export")
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	err = h.ExportBundle(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var manifest backend.BundleManifest
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &manifest))
+	require.Len(t, manifest.Volumes, 2)
+	for _, v := range manifest.Volumes {
+		require.Equal(t, "local", v.Driver)
+		require.Equal(t, "shop", v.Labels["app"])
+	}
+
+	// Importing volumes that already exist fails
+	bundlePath := filepath.Join(tmpDir, "shop.bundle.tar")
+	q = make(url.Values)
+	q.Set("path", bundlePath)
+	req = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/bundles/import")
+
+	err = h.ImportBundle(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	// Remove one of the volumes and import the other one under a new name
+	var dbID, cacheID string
+	for volumeID, content := range volumes {
+		if content == "db" {
+			dbID = volumeID
+		} else {
+			cacheID = volumeID
+		}
+	}
+	require.NoError(t, cli.VolumeRemove(context.Background(), dbID, true))
+
+	q = make(url.Values)
+	q.Set("path", bundlePath)
+	q.Add("rename", cacheID+":"+renamed)
+	req = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/bundles/import")
+
+	err = h.ImportBundle(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	runInVolume(t, cli, dbID, "grep -q db /data/content.txt")
+	runInVolume(t, cli, renamed, "grep -q cache /data/content.txt")
+	v, err := cli.VolumeInspect(context.Background(), renamed)
+	require.NoError(t, err)
+	require.Equal(t, "shop", v.Labels["app"])
+}
+
+func TestImportBundleWithInvalidRenameShouldFail(t *testing.T) {
+	for _, rename := range []string{"volume", ":volume", "volume:", "volume:$(reboot)", "volume:../etc"} {
+		t.Run(rename, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", "/tmp/bundle.tar")
+			q.Add("rename", rename)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/bundles/import")
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Import bundle
+			err := h.ImportBundle(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
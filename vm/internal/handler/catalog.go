@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// Backups lists all the backups of the catalog, the most recent first: the images saved from volumes, and the archives, layouts,
+// bundles and registry references made by the extension, see backend.Catalog.
+func (h *Handler) Backups(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	backups, err := h.Catalog.Backups(ctxReq, cli)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, backups)
+}
+
+// VolumeBackups lists the backups of the catalog that were made from the volume, the most recent first.
+func (h *Handler) VolumeBackups(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	volumeName := ctx.Param("volume")
+
+	if volumeName == "" {
+		return ctx.String(http.StatusBadRequest, "volume is required")
+	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	backups, err := h.Catalog.Backups(ctxReq, cli)
+	if err != nil {
+		return err
+	}
+
+	volumeBackups := []backend.Backup{}
+	for _, b := range backups {
+		if b.Volume == volumeName {
+			volumeBackups = append(volumeBackups, b)
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, volumeBackups)
+}
+
+// DeleteBackup deletes the backup "id" from the catalog. Images are removed, while archives, layouts, bundles and registry references
+// are only removed from the catalog. The response is the deleted backup.
+func (h *Handler) DeleteBackup(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	id := ctx.Param("id")
+
+	if id == "" {
+		return ctx.String(http.StatusBadRequest, "id is required")
+	}
+
+	log.Infof("id: %s", id)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	backup, err := h.Catalog.Delete(ctxReq, cli, id)
+	if errors.Is(err, backend.ErrBackupNotFound) {
+		return ctx.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, backup)
+}
+
+// trackBackup adds the backup to the catalog. A backup that can't be added is only logged, since it was made anyway.
+func (h *Handler) trackBackup(backup backend.Backup) {
+	if err := h.Catalog.Track(backup); err != nil {
+		log.Warnf("adding %s %s of volume %s to the catalog: %s", backup.Kind, backup.Location, backup.Volume, err)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestVolumeBackups(t *testing.T) {
+	volumeID := "8a0c2e4b6d8f0a2c4e6b8d0f2a4c6e8b0d2f4a6c8e0b2d4f6a8c0e2b4d6f8a0c"
+	image := "vackup-catalog-test-img:latest"
+	cli := setupDockerClient(t)
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_, _ = cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true})
+	}()
+	_, err = backend.Save(context.Background(), cli, volumeID, image, "", backend.Filter{})
+	require.NoError(t, err)
+
+	e := echo.New()
+	h := New(context.Background(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+	archive := backend.NewBackup(backend.BackupArchive, volumeID, "/backups/db.tar.gz", time.Now().Add(-time.Hour))
+	h.trackBackup(archive)
+
+	// List the backups of the volume
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/backups")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+
+	err = h.VolumeBackups(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var backups []backend.Backup
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &backups))
+	require.Len(t, backups, 2)
+	require.Equal(t, backend.BackupImage, backups[0].Kind)
+	require.Equal(t, image, backups[0].Location)
+	require.Equal(t, archive.ID, backups[1].ID)
+
+	// Delete the image
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/backups/:id/delete")
+	c.SetParamNames("id")
+	c.SetParamValues(backups[0].ID)
+
+	err = h.DeleteBackup(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, _, err = cli.ImageInspectWithRaw(context.Background(), image)
+	require.True(t, client.IsErrNotFound(err))
+}
+
+func TestDeleteBackupWithInvalidIDShouldFail(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/backups/:id/delete")
+	c.SetParamNames("id")
+	c.SetParamValues("")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+		Catalog:       backend.NewCatalog(""),
+	}
+
+	err := h.DeleteBackup(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestVolumeBackupsWithHostileInputShouldFail(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/backups")
+	c.SetParamNames("volume")
+	c.SetParamValues("$(reboot)")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+		Catalog:       backend.NewCatalog(""),
+	}
+
+	err := h.VolumeBackups(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
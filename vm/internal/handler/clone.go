@@ -1,20 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"runtime"
 
 	volumetypes "github.com/docker/docker/api/types/volume"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/labstack/echo/v4"
 
-	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
@@ -27,9 +21,15 @@ func (h *Handler) CloneVolume(ctx echo.Context) error {
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	if destVolume == "" {
 		return ctx.String(http.StatusBadRequest, "destVolume is required")
 	}
+	if err := backend.ValidateVolumeName(destVolume); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("destVolume: %s", destVolume)
@@ -46,8 +46,13 @@ func (h *Handler) CloneVolume(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("clone", volumeSize(ctxReq, cli, volumeName))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "clone"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
@@ -66,18 +71,6 @@ func (h *Handler) CloneVolume(ctx echo.Context) error {
 		return err
 	}
 
-	// Ensure the image is present before creating the container
-	reader, err := cli.ImagePull(ctxReq, internal.BusyboxImage, types.ImagePullOptions{
-		Platform: "linux/" + runtime.GOARCH,
-	})
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(os.Stdout, reader)
-	if err != nil {
-		return err
-	}
-
 	// Create destination volume with the same labels as the source volume
 	volInspect, err := cli.VolumeInspect(ctx.Request().Context(), volumeName)
 	if err != nil {
@@ -92,61 +85,11 @@ func (h *Handler) CloneVolume(ctx echo.Context) error {
 	}
 
 	// Clone
-	resp, err := cli.ContainerCreate(ctxReq, &container.Config{
-		Image:        internal.BusyboxImage,
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", "cd /from ; cp -av . /to"},
-		User:         "root",
-		Labels: map[string]string{
-			"com.docker.desktop.extension":                    "true",
-			"com.docker.desktop.extension.name":               "Volumes Backup & Share",
-			"com.docker.compose.project":                      "docker_volumes-backup-extension-desktop-extension",
-			"com.volumes-backup-extension.action":             "clone",
-			"com.volumes-backup-extension.volume":             volumeName,
-			"com.volumes-backup-extension.destination-volume": destVolume,
-		},
-	}, &container.HostConfig{
-		Binds: []string{
-			volumeName + ":" + "/from",
-			destVolume + ":" + "/to",
-		},
-	}, nil, nil, "")
-	if err != nil {
-		return err
+	err = backend.CopyVolume(ctxReq, cli, volumeName, destVolume, backend.ImportStrategyMerge)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusInternalServerError, exitErr.Error())
 	}
-
-	if err := cli.ContainerStart(ctxReq, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return err
-	}
-
-	var exitCode int64
-	statusCh, errCh := cli.ContainerWait(ctxReq, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
-		}
-	case status := <-statusCh:
-		log.Infof("status: %#+v\n", status)
-		exitCode = status.StatusCode
-	}
-
-	out, err := cli.ContainerLogs(ctxReq, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
-	if err != nil {
-		return err
-	}
-
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-	if err != nil {
-		return err
-	}
-
-	if exitCode != 0 {
-		return ctx.String(http.StatusInternalServerError, fmt.Sprintf("container exited with status code %d\n", exitCode))
-	}
-
-	err = cli.ContainerRemove(ctxReq, resp.ID, types.ContainerRemoveOptions{})
 	if err != nil {
 		return err
 	}
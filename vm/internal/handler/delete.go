@@ -16,6 +16,9 @@ func (h *Handler) DeleteVolume(ctx echo.Context) error {
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 
 	log.Infof("volumeName: %s", volumeName)
 
@@ -32,7 +35,7 @@ func (h *Handler) DeleteVolume(ctx echo.Context) error {
 	}()
 
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "delete"
+	h.ProgressCache.m[volumeName] = backend.NewProgress("delete", 0)
 	h.ProgressCache.Unlock()
 
 	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/crypt"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// diffSeparator separates the files to import from the files of the volume in the output of the dry-run container.
+// It cannot be mistaken for a file line as it does not contain any tab.
+const diffSeparator = "---vackup-diff-separator---"
+
+// importDryRun responds with a JSON report of what importing the archive located in the host at "path" would change in the volume.
+// The archive is read entirely, but the containers using the volume are not stopped and the volume is mounted read-only.
+func (h *Handler) importDryRun(ctx echo.Context, cli *client.Client, volumeName, path string, entries []string, strategy backend.ImportStrategy) error {
+	ctxReq := ctx.Request().Context()
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	header, err := peekHostFile(ctxReq, cli, path, 512)
+	if err != nil {
+		if strings.Contains(err.Error(), "bind source path does not exist") {
+			return ctx.String(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+	if crypt.IsEncrypted(header) {
+		return ctx.String(http.StatusBadRequest, "dry run is not supported for encrypted archives")
+	}
+	compression, err := backend.DetectCompression(header)
+	if err != nil {
+		return ctx.String(http.StatusUnsupportedMediaType, err.Error())
+	}
+
+	tarCmd := "tar"
+	if compression.Program != "" {
+		tarCmd += " -I " + compression.Program
+	}
+
+	// List the regular files of the archive without extracting them, then the regular files of the volume
+	cmd := fmt.Sprintf("%s -xf /vackup -C /tmp --to-command=\"$VACKUP_DIFF_CMD\" && echo %s && %s",
+		tarCmd, diffSeparator, backend.DiffDirCmd("/vackup-volume"))
+	log.Infof("cmd: %s", cmd)
+
+	var out bytes.Buffer
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(cmd),
+		Env:   []string{"VACKUP_DIFF_CMD=" + backend.DiffArchiveCmd},
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "dry-run",
+			"com.volumes-backup-extension.volume": volumeName,
+			"com.volumes-backup-extension.path":   path,
+		},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup", ReadOnly: true},
+			{Type: mount.TypeVolume, Source: volumeName, Target: "/vackup-volume", ReadOnly: true},
+		},
+	}, &out)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "archive could not be read: "+strings.TrimSpace(exitErr.Error()))
+	}
+	if err != nil {
+		return err
+	}
+
+	return diffReport(ctx, out.String(), entries, strategy)
+}
+
+// loadDryRun responds with a JSON report of what loading the image into the volume would change.
+// The files of the image are listed from its archive without running it. The containers using the volume are not stopped
+// and the volume is mounted read-only in a helper container.
+func (h *Handler) loadDryRun(ctx echo.Context, cli *client.Client, volumeName, image string, strategy backend.ImportStrategy) error {
+	ctxReq := ctx.Request().Context()
+
+	data, err := backend.OpenImageData(ctxReq, cli, volumeName, image)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	incoming, err := backend.ParseImageDataDiffEntries(data)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.BusyboxImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	cmd := backend.DiffDirCmd("/mount-volume")
+	log.Infof("cmd: %s", cmd)
+
+	var out bytes.Buffer
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image: internal.BusyboxImage,
+		Cmd:   backend.ShellCmd(cmd),
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "dry-run",
+			"com.volumes-backup-extension.image":  image,
+			"com.volumes-backup-extension.volume": volumeName,
+		},
+	}, &container.HostConfig{
+		Binds: []string{
+			volumeName + ":" + "/mount-volume:ro",
+		},
+	}, &out)
+	if err != nil {
+		return err
+	}
+
+	current, err := backend.ParseDiffEntries(&out)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, backend.Diff(incoming, current, strategy))
+}
+
+// diffReport parses the output of a dry-run container and responds with the differences between the files to import and the files of the volume.
+// If entries are given, only the files of these entries are imported and the other files of the volume are kept.
+func diffReport(ctx echo.Context, out string, entries []string, strategy backend.ImportStrategy) error {
+	i := strings.Index(out, diffSeparator+"\n")
+	if i == -1 {
+		return ctx.String(http.StatusInternalServerError, "files could not be listed")
+	}
+
+	incoming, err := backend.ParseDiffEntries(strings.NewReader(out[:i]))
+	if err != nil {
+		return err
+	}
+	current, err := backend.ParseDiffEntries(strings.NewReader(out[i+len(diffSeparator)+1:]))
+	if err != nil {
+		return err
+	}
+
+	// Archives exported by version 1.0.0 of the extension store the content of the volume in a root folder named "vackup-volume"
+	legacy := len(incoming) > 0
+	for _, e := range incoming {
+		if !strings.HasPrefix(e.Path, "vackup-volume/") {
+			legacy = false
+			break
+		}
+	}
+	if legacy {
+		for i := range incoming {
+			incoming[i].Path = strings.TrimPrefix(incoming[i].Path, "vackup-volume/")
+		}
+	}
+
+	if len(entries) > 0 {
+		var selected []backend.DiffEntry
+		for _, e := range incoming {
+			if inEntries(e.Path, entries) {
+				selected = append(selected, e)
+			}
+		}
+		incoming = selected
+	}
+
+	return ctx.JSON(http.StatusOK, backend.Diff(incoming, current, stagingStrategy(strategy, entries)))
+}
+
+// inEntries reports whether the file at path p, relative to the root of the volume, is one of the entries or is inside one of them.
+func inEntries(p string, entries []string) bool {
+	for _, entry := range entries {
+		entry = strings.TrimPrefix(path.Clean("/"+entry), "/")
+		if p == entry || strings.HasPrefix(p, entry+"/") {
+			return true
+		}
+	}
+	return false
+}
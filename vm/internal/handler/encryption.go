@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+const (
+	// encryptionAES256GCM is the only encryption supported for archives, see the crypt package.
+	encryptionAES256GCM = "aes-256-gcm"
+	// encryptedExt is the extension of encrypted archives.
+	encryptedExt = ".enc"
+	// passphraseHeader is the request header that carries the passphrase of an encrypted archive.
+	// A header is used instead of a query parameter so that the passphrase does not end up in the request logs.
+	passphraseHeader = "X-Vackup-Passphrase"
+)
+
+// secretFromRequest returns the secret used to encrypt or decrypt an archive: either the passphrase sent in the X-Vackup-Passphrase header,
+// or the content of the file located in the host at the "keyFile" query parameter. It returns nil if none of them is given.
+func secretFromRequest(ctx echo.Context, cli *client.Client) ([]byte, error) {
+	if passphrase := ctx.Request().Header.Get(passphraseHeader); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	keyFile := ctx.QueryParam("keyFile")
+	if keyFile == "" {
+		return nil, nil
+	}
+	if err := backend.ValidateHostPath(keyFile); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	key, err := readHostFile(ctx.Request().Context(), cli, keyFile)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "key file could not be read: "+err.Error())
+	}
+	if len(key) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "key file is empty")
+	}
+
+	return key, nil
+}
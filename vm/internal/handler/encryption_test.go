@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/crypt"
+)
+
+func TestExportAndImportEncryptedVolume(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumeID := "3a7f0c1e9b8d4f6a2c5e7b9d1f3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a"
+	restoredVolume := volumeID + "-restored"
+	setupVolume(context.Background(), cli, volumeID, "docker.io/library/nginx:1.21", "/usr/share/nginx/html:ro")
+
+	tmpDir, err := os.MkdirTemp("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_ = cli.VolumeRemove(context.Background(), restoredVolume, true)
+	}()
+
+	// Export the volume into an encrypted archive
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", tmpDir)
+	q.Set("fileName", "backup.tar.zst")
+	q.Set("encryption", "aes-256-gcm")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	req.Header.Set(passphraseHeader, "correct horse battery staple")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/export")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	err = h.ExportVolume(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	archive := filepath.Join(tmpDir, "backup.tar.zst.enc")
+	b, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.True(t, crypt.IsEncrypted(b))
+
+	// Populate the volume to restore into, to check it's left untouched on failures
+	_, err = cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   restoredVolume,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runInVolume(t, cli, restoredVolume, "echo keep > /data/keep.txt")
+
+	importEncrypted := func(passphrase string) *httptest.ResponseRecorder {
+		q := make(url.Values)
+		q.Set("path", archive)
+		req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+		if passphrase != "" {
+			req.Header.Set(passphraseHeader, passphrase)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/volumes/:volume/import")
+		c.SetParamNames("volume")
+		c.SetParamValues(restoredVolume)
+
+		err := h.ImportTarGzFile(c)
+		require.NoError(t, err)
+		return rec
+	}
+
+	// Without passphrase
+	rec = importEncrypted("")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	runInVolume(t, cli, restoredVolume, "test -e /data/keep.txt")
+
+	// With a wrong passphrase
+	rec = importEncrypted("wrong passphrase")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	runInVolume(t, cli, restoredVolume, "test -e /data/keep.txt")
+
+	// With the right passphrase
+	rec = importEncrypted("correct horse battery staple")
+	require.Equal(t, http.StatusOK, rec.Code)
+	runInVolume(t, cli, restoredVolume, "test ! -e /data/keep.txt && test -e /data/index.html && test -e /data/50x.html")
+}
@@ -1,49 +1,182 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/crypt"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
+// ExportVolume exports the content of a volume into a compressed tar archive.
+// By default, the archive is written to the host directory given by "path".
+// If "stream" is true, the archive is streamed as the response body instead and "path" is not needed.
+// In both cases, the compression is chosen from the extension of "fileName", unless it is set explicitly with "compression"
+// (none, gzip, zstd, bzip2, xz or lz4). The compression level can be set with "level", e.g. 1 for lz4 or 19 for zstd.
+// Only the files matching the "include" glob patterns are archived, if any, and the files matching the "exclude" glob patterns
+// or the patterns listed in the .backupignore file at the root of the volume are left out.
+// If "incremental" is true, only the files that changed since the previous incremental export are archived.
+// The state of the previous export is kept in the snapshot file "snapshot" (by default "<volume>.snar") next to the archive in "path".
+// The first export with a snapshot file that does not exist yet is a full export and is the base of the chain.
+// Exports written to "path" come with a manifest file ("<fileName>.manifest") with the size, mode and checksum of every file,
+// and a metadata file ("<fileName>.volume.json") with the driver, the driver options and the labels of the volume.
+// If "encryption" is "aes-256-gcm", the archive is encrypted with the passphrase sent in the X-Vackup-Passphrase header
+// or the content of the host file "keyFile", and ".enc" is appended to "fileName" if needed.
+// Encrypted archives are authenticated, so they come without a (plaintext) manifest.
+// If "maxPartSize" is set, e.g. to "4G", the archive written to "path" is split into numbered parts of at most this size
+// ("<fileName>.part001", "<fileName>.part002", ...) that come with an index file ("<fileName>.index") with the checksum of every part.
 func (h *Handler) ExportVolume(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
 	path := ctx.QueryParam("path")
 	fileName := ctx.QueryParam("fileName")
+	stream := ctx.QueryParam("stream") == "true"
+	incremental := ctx.QueryParam("incremental") == "true"
+	snapshot := ctx.QueryParam("snapshot")
+	encryption := ctx.QueryParam("encryption")
+	compressionName := ctx.QueryParam("compression")
+	levelParam := ctx.QueryParam("level")
+	maxPartSizeParam := ctx.QueryParam("maxPartSize")
+	filter := backend.Filter{
+		Include: ctx.QueryParams()["include"],
+		Exclude: ctx.QueryParams()["exclude"],
+	}
 
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
-	if path == "" {
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	compression, ok := backend.CompressionByName(compressionName)
+	if compressionName != "" && !ok {
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("unsupported compression %q", compressionName))
+	}
+	if stream && fileName == "" {
+		fileName = volumeName + ".tar.zst"
+		if compressionName != "" {
+			fileName = volumeName + ".tar"
+			if compression.Program != "" {
+				fileName += compression.Ext
+			}
+		}
+	}
+	if path == "" && !stream {
 		return ctx.String(http.StatusBadRequest, "path is required")
 	}
 	if fileName == "" {
-		return ctx.String(http.StatusBadRequest, "path is required")
+		return ctx.String(http.StatusBadRequest, "fileName is required")
+	}
+	if err := backend.ValidateFileName(fileName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if path != "" {
+		if err := backend.ValidateHostPath(path); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+	}
+	if incremental && stream {
+		return ctx.String(http.StatusBadRequest, "incremental exports cannot be streamed")
+	}
+	if incremental && snapshot == "" {
+		snapshot = volumeName + ".snar"
+	}
+	if snapshot != "" {
+		if err := backend.ValidateFileName(snapshot); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+	}
+	level := backend.UnsetLevel
+	if levelParam != "" {
+		l, err := strconv.Atoi(levelParam)
+		if err != nil || l < 0 {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid level %q", levelParam))
+		}
+		level = l
+	}
+	if err := filter.Validate(); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	var maxPartSize int64
+	if maxPartSizeParam != "" {
+		size, err := units.RAMInBytes(maxPartSizeParam)
+		if err != nil || size <= 0 {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid maxPartSize %q", maxPartSizeParam))
+		}
+		maxPartSize = size
+	}
+	if maxPartSize > 0 && (stream || incremental || encryption != "") {
+		return ctx.String(http.StatusBadRequest, "only non-incremental, non-encrypted exports written to path can be split into parts")
+	}
+	if encryption != "" && encryption != encryptionAES256GCM {
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("unsupported encryption %q", encryption))
+	}
+	if encryption != "" && incremental {
+		return ctx.String(http.StatusBadRequest, "incremental exports cannot be encrypted")
+	}
+	if encryption != "" && !strings.HasSuffix(fileName, encryptedExt) {
+		fileName += encryptedExt
 	}
 
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("path: %s", path)
 	log.Infof("fileName: %s", fileName)
+	log.Infof("stream: %t", stream)
+	log.Infof("incremental: %t", incremental)
+	log.Infof("snapshot: %s", snapshot)
+	log.Infof("encryption: %s", encryption)
+	log.Infof("filter: %+v", filter)
+	log.Infof("maxPartSize: %d", maxPartSize)
+
+	// The compression of encrypted archives is chosen from the extension that precedes ".enc"
+	fileExt := filepath.Ext(strings.TrimSuffix(fileName, encryptedExt))
+	log.Infof("fileExt: %s", fileExt)
+
+	if compressionName == "" {
+		compression = backend.CompressionByExt(fileExt)
+	}
+	log.Infof("compression: %s", compression.Name)
+
+	compressProgram, err := compression.CompressProgram(level)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 
 	cli, err := h.DockerClient()
 	if err != nil {
 		return err
 	}
 
+	var secret []byte
+	if encryption != "" {
+		secret, err = secretFromRequest(ctx, cli)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return ctx.String(http.StatusBadRequest, "a passphrase or a key file is required to encrypt the archive")
+		}
+	}
+
 	defer func() {
 		h.ProgressCache.Lock()
 		delete(h.ProgressCache.m, volumeName)
@@ -51,53 +184,154 @@ func (h *Handler) ExportVolume(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("export", volumeSize(ctxReq, cli, volumeName))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "export"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
 		return err
 	}
 
+	metadata, err := backend.GetVolumeMetadata(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The helper image might lack the compression program, which must fail the export before the containers are stopped
+	if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, compressProgram); err != nil {
+		return requireHelperCommand(ctx, err)
+	}
+	if maxPartSize > 0 {
+		// The parts are numbered by GNU split: busybox split, in older versions of the image, only names them with letters
+		if err := backend.RequireCommand(ctxReq, cli, internal.AlpineTarZstdImage, "split --numeric-suffixes", "split", "--numeric-suffixes=1", "/dev/null", "/tmp/part"); err != nil {
+			return requireHelperCommand(ctx, err)
+		}
+	}
+
 	// Stop container(s)
 	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
 	if err != nil {
 		return err
 	}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		// The containers are started again if the export failed, even if the request was canceled
+		if err := backend.StartContainersByName(context.Background(), cli, stoppedContainers); err != nil {
+			log.Error(err)
+		}
+	}()
 
-	var compressProgram string
-	tarOpts := "-cvf"
+	if stream {
+		streamErr := h.streamVolume(ctx, cli, volumeName, fileName, compression.ContentType, compressProgram, filter, secret)
 
-	fileExt := filepath.Ext(fileName)
-	log.Infof("fileExt: %s", fileExt)
+		// Start container(s), even if the client went away while the volume was streamed
+		started = true
+		err = backend.StartContainersByName(context.Background(), cli, stoppedContainers)
+
+		// Once the first bytes of the archive were sent, the status can no longer change, so the errors are only logged
+		if ctx.Response().Committed {
+			if streamErr != nil {
+				log.Error(streamErr)
+			}
+			if err != nil {
+				log.Error(err)
+			}
+			return nil
+		}
+		if streamErr != nil {
+			return streamErr
+		}
+		return err
+	}
+
+	if secret != nil {
+		if err := h.exportEncrypted(ctxReq, cli, volumeName, path, fileName, compressProgram, filter, metadata, secret); err != nil {
+			return err
+		}
+
+		// Start container(s)
+		started = true
+		err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+		if err != nil {
+			return err
+		}
 
-	switch fileExt {
-	case ".gz":
-		compressProgram = "\"pigz -6 -k\"" // pigz (parallel implementation of gzip), use -6 as the default compression level (-1 is fastest, -9 is best), "-k" to not delete the original file after processing
-	case ".zst":
-		compressProgram = "zstdmt" // zstdmt is equivalent to zstd -T0 (attempt to detect and use the number of physical CPU cores)
-	case ".bz2":
-		compressProgram = "bzip2"
-	default:
-		compressProgram = ""
+		h.trackBackup(backend.NewBackup(backend.BackupArchive, volumeName, filepath.Join(path, fileName), time.Now()))
+		return ctx.String(http.StatusCreated, "")
 	}
 
 	// Export
-	cmd := []string{"tar"}
+	// The names of the files are passed to the script in environment variables, see backend.ShellCmd
+	env := append([]string{
+		"VACKUP_FILE_NAME=" + filepath.Base(fileName),
+		"VACKUP_ARCHIVE=" + "/vackup" + "/" + filepath.Base(fileName),
+		"VACKUP_MANIFEST_CMD=" + backend.ManifestCmd,
+		"VACKUP_VOLUME_METADATA=" + metadata.String(),
+	}, filter.Env()...)
+
+	var opts []string
 
 	if compressProgram != "" {
-		cmd = append(cmd, "-I", compressProgram)
+		// quote the program and its arguments as tar expects a single argument
+		opts = append(opts, "-I", "\""+compressProgram+"\"")
 	}
 
-	cmd = append(cmd,
-		tarOpts,
-		"/vackup"+"/"+filepath.Base(fileName), // the .tar.zst file
-		"-C",                                  // -C is used to not include the parent directory
-		"/vackup-volume",                      // the directory where the files to compress are
-		".")
+	if incremental {
+		// tar compares the files against the metadata stored in the snapshot file, archives the new and changed ones,
+		// records the deleted ones, and then updates the snapshot file for the next incremental export.
+		opts = append(opts, `--listed-incremental="$VACKUP_SNAPSHOT"`)
+		env = append(env, "VACKUP_SNAPSHOT="+"/vackup"+"/"+filepath.Base(snapshot))
+	}
 
-	cmdJoined := strings.Join(cmd, " ")
-	log.Infof("cmdJoined: %s", cmdJoined)
+	var script string
+	if maxPartSize == 0 {
+		opts = append(opts,
+			backend.ProgressTarOpts,
+			"-cf",
+			`"$VACKUP_ARCHIVE"`) // the .tar.zst file
+
+		// tar runs from the directory where the files to compress are, to not include the parent directory
+		script = filter.TarScript("/vackup-volume", opts...)
+
+		// Once the archive is written, read it back to write a manifest with the checksum of every file next to it,
+		// so that the integrity of the archive can be verified later without having to import it.
+		script += ` && tar -xf "$VACKUP_ARCHIVE" -C /tmp --to-command="$VACKUP_MANIFEST_CMD" > "$VACKUP_ARCHIVE"` + backend.ManifestExt
+	} else {
+		// The parts of a previous export of the same archive are removed, as there might be more of them.
+		// tar writes the archive to stdout, and split writes it into numbered parts starting at 001.
+		var decompressOpts string
+		if compressProgram != "" {
+			decompressOpts = "-I \"" + compressProgram + "\" "
+		}
+		opts = append(opts, backend.ProgressTarOpts, "-cf", "-")
+		script = fmt.Sprintf(`set -o pipefail; rm -f %s && %s | split -b %d --numeric-suffixes=1 -a 3 - "$VACKUP_ARCHIVE"%s`,
+			partsGlob(`"$VACKUP_ARCHIVE"`), filter.TarScript("/vackup-volume", opts...), maxPartSize, partExt)
+		script += fmt.Sprintf(` && cd /vackup && sha256sum %s > "$VACKUP_FILE_NAME"%s`, partsGlob(`"$VACKUP_FILE_NAME"`), partIndexExt)
+		script += fmt.Sprintf(` && cat %s | tar %s-xf - -C /tmp --to-command="$VACKUP_MANIFEST_CMD" > "$VACKUP_ARCHIVE"%s`,
+			partsGlob(`"$VACKUP_ARCHIVE"`), decompressOpts, backend.ManifestExt)
+	}
+	script += " && " + writeMetadataScript
+	log.Infof("script: %s", script)
 
 	binds := []string{
 		volumeName + ":" + "/vackup-volume",
@@ -105,23 +339,12 @@ func (h *Handler) ExportVolume(ctx echo.Context) error {
 	}
 	log.Infof("binds: %+v", binds)
 
-	// Ensure the image is present before creating the container
-	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
-		Platform: "linux/" + runtime.GOARCH,
-	})
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(os.Stdout, reader)
-	if err != nil {
-		return err
-	}
-
 	resp, err := cli.ContainerCreate(ctxReq, &container.Config{
 		Image:        internal.AlpineTarZstdImage,
 		AttachStdout: true,
 		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", cmdJoined},
+		Cmd:          backend.ShellCmd(script, filter.Args()...),
+		Env:          env,
 		User:         "root",
 		Labels: map[string]string{
 			"com.docker.desktop.extension":          "true",
@@ -138,6 +361,10 @@ func (h *Handler) ExportVolume(ctx echo.Context) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		// The container is also removed if the export failed
+		_ = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+	}()
 
 	if err := cli.ContainerStart(ctxReq, resp.ID, types.ContainerStartOptions{}); err != nil {
 		return err
@@ -169,16 +396,145 @@ func (h *Handler) ExportVolume(ctx echo.Context) error {
 		return ctx.String(http.StatusInternalServerError, fmt.Sprintf("container exited with status code %d\n", exitCode))
 	}
 
-	err = cli.ContainerRemove(ctxReq, resp.ID, types.ContainerRemoveOptions{})
+	// Start container(s)
+	started = true
+	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
 	if err != nil {
 		return err
 	}
 
-	// Start container(s)
-	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	h.trackBackup(backend.NewBackup(backend.BackupArchive, volumeName, filepath.Join(path, fileName), time.Now()))
+	return ctx.String(http.StatusCreated, "")
+}
+
+// streamVolume writes the compressed content of the volume to the response body as it is produced by the tar command.
+// If secret is not nil, the archive is encrypted before being written.
+func (h *Handler) streamVolume(ctx echo.Context, cli *client.Client, volumeName, fileName, contentType, compressProgram string, filter backend.Filter, secret []byte) error {
+	ctxReq := ctx.Request().Context()
+
+	if secret != nil {
+		contentType = echo.MIMEOctetStream
+	}
+	ctx.Response().Header().Set(echo.HeaderContentType, contentType)
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(fileName),
+	}))
+
+	config, hostConfig := tarToStdoutConfig(volumeName, fileName, compressProgram, filter)
+
+	// The response status is sent along with the first bytes of the archive,
+	// so an error before the container produces any output still results in an error response.
+	if secret == nil {
+		return backend.StreamFromContainer(ctxReq, cli, config, hostConfig, ctx.Response())
+	}
+
+	cw, err := crypt.NewWriter(ctx.Response(), secret)
 	if err != nil {
 		return err
 	}
+	if err := backend.StreamFromContainer(ctxReq, cli, config, hostConfig, cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
 
-	return ctx.String(http.StatusCreated, "")
+// exportEncrypted encrypts the compressed content of the volume and writes it to the file "fileName" in the host directory "path".
+// The archive is produced by a first container, encrypted by the backend and written by a second container, so the plaintext never reaches the host.
+func (h *Handler) exportEncrypted(ctxReq context.Context, cli *client.Client, volumeName, path, fileName, compressProgram string, filter backend.Filter, metadata backend.VolumeMetadata, secret []byte) error {
+	pr, pw := io.Pipe()
+
+	g, gCtx := errgroup.WithContext(ctxReq)
+	g.Go(func() error {
+		cw, err := crypt.NewWriter(pw, secret)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return err
+		}
+
+		config, hostConfig := tarToStdoutConfig(volumeName, fileName, compressProgram, filter)
+		err = backend.StreamFromContainer(gCtx, cli, config, hostConfig, cw)
+		if err == nil {
+			err = cw.Close()
+		}
+
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		script := writeMetadataScript + ` && cat > "$VACKUP_ARCHIVE"`
+		log.Infof("script: %s", script)
+
+		err := backend.StreamIntoContainer(gCtx, cli, &container.Config{
+			Image: internal.AlpineTarZstdImage,
+			Cmd:   backend.ShellCmd(script),
+			Env: []string{
+				"VACKUP_ARCHIVE=" + "/vackup" + "/" + filepath.Base(fileName),
+				"VACKUP_VOLUME_METADATA=" + metadata.String(),
+			},
+			User: "root",
+			Labels: map[string]string{
+				"com.docker.desktop.extension":          "true",
+				"com.docker.desktop.extension.name":     "Volumes Backup & Share",
+				"com.docker.compose.project":            "docker_volumes-backup-extension-desktop-extension",
+				"com.volumes-backup-extension.action":   "export",
+				"com.volumes-backup-extension.volume":   volumeName,
+				"com.volumes-backup-extension.path":     path,
+				"com.volumes-backup-extension.fileName": fileName,
+			},
+		}, &container.HostConfig{
+			Binds: []string{
+				path + ":" + "/vackup",
+			},
+		}, pr)
+
+		// Unblock the encryption if the archive can't be written
+		_ = pr.CloseWithError(err)
+		return err
+	})
+
+	return g.Wait()
+}
+
+// writeMetadataScript is a shell script that writes the metadata of the volume, passed in the VACKUP_VOLUME_METADATA
+// environment variable, next to the archive whose path is passed in the VACKUP_ARCHIVE environment variable.
+const writeMetadataScript = `printf '%s' "$VACKUP_VOLUME_METADATA" > "$VACKUP_ARCHIVE"` + backend.MetadataExt
+
+// tarToStdoutConfig returns the configuration of a container that writes the compressed content of the volume selected by the filter to its stdout.
+func tarToStdoutConfig(volumeName, fileName, compressProgram string, filter backend.Filter) (*container.Config, *container.HostConfig) {
+	// tar writes the archive to stdout when "-f -" is used
+	var opts, scriptOpts []string
+	if compressProgram != "" {
+		opts = append(opts, "-I", compressProgram)
+		// quote the program and its arguments in the script as tar expects a single argument
+		scriptOpts = append(scriptOpts, "-I", "\""+compressProgram+"\"")
+	}
+	opts = append(opts, "-cf", "-")
+	scriptOpts = append(scriptOpts, "-cf", "-", backend.ProgressTarOpts)
+
+	cmd, ok := filter.TarCmd("/vackup-volume", append(opts, backend.ProgressTarArgs...)...)
+	if !ok {
+		// A shell is only needed to match the include patterns with find
+		cmd = backend.ShellCmd(filter.TarScript("/vackup-volume", scriptOpts...), filter.Args()...)
+	}
+	log.Infof("cmd: %v", cmd)
+
+	return &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   cmd,
+		Env:   filter.Env(),
+		User:  "root",
+		Labels: map[string]string{
+			"com.docker.desktop.extension":          "true",
+			"com.docker.desktop.extension.name":     "Volumes Backup & Share",
+			"com.docker.compose.project":            "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action":   "export",
+			"com.volumes-backup-extension.volume":   volumeName,
+			"com.volumes-backup-extension.fileName": fileName,
+		},
+	}, &container.HostConfig{
+		Binds: []string{
+			volumeName + ":" + "/vackup-volume:ro",
+		},
+	}
 }
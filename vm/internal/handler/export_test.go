@@ -19,6 +19,7 @@ import (
 
 	"github.com/klauspost/compress/zstd"
 
+	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/log"
 
 	"github.com/docker/docker/api/types"
@@ -51,6 +52,7 @@ func TestExportVolume(t *testing.T) {
 
 			defer func() {
 				_ = os.Remove(archiveFileName)
+				_ = os.Remove(archiveFileName + backend.ManifestExt)
 			}()
 
 			// Export volume
@@ -100,6 +102,355 @@ func TestExportVolume(t *testing.T) {
 	_ = cli.VolumeRemove(context.Background(), volume, true)
 }
 
+func TestExportVolumeStream(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volume := "5c2ed1a5ab2c4c4cd3dfc6b4f0e9b55b5e6ac6de0a4c24f6db1c9e3f8dc0ff4a"
+	image := "docker.io/library/nginx:1.21"
+	mountPath := "/usr/share/nginx/html:ro"
+
+	setupVolume(context.Background(), cli, volume, image, mountPath)
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volume, true)
+	}()
+
+	tmpDir := os.TempDir()
+
+	compressions := map[string]string{
+		".tar.gz":  "application/gzip",
+		".tar.zst": "application/zstd",
+		".tar.bz2": "application/x-bzip2",
+	}
+
+	for compression, contentType := range compressions {
+		t.Run(fmt.Sprintf("TestExportVolumeStream_%s_%s", image, compression), func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("stream", "true")
+			q.Set("fileName", volume+compression)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export")
+			c.SetParamNames("volume")
+			c.SetParamValues(volume)
+			h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+			// Export volume
+			err := h.ExportVolume(c)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, contentType, rec.Header().Get(echo.HeaderContentType))
+			require.Equal(t, fmt.Sprintf("attachment; filename=%s", volume+compression), rec.Header().Get(echo.HeaderContentDisposition))
+
+			// Check content of the response body is correct
+			dst := filepath.Join(tmpDir, fmt.Sprintf("export-stream-destination-%s", compression))
+			defer func() {
+				if err = os.RemoveAll(dst); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			if err := extractArchive(t, compression, dst, rec.Body); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := filepath.Join("testdata", "export", "vackup-volume")
+			for _, f := range []string{"50x.html", "index.html"} {
+				require.Equal(t, string(readFile(t, dir, f+".golden")), string(readFile(t, dst, f)))
+			}
+		})
+	}
+}
+
+func TestExportVolumeStreamWithCompressionLevel(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volume := "e3a9c1f7b5d2048e6c1a3f5b7d9e0c2a4f6b8d0e1c3a5f7b9d2e4c6a8f0b1d3e"
+	image := "docker.io/library/nginx:1.21"
+	mountPath := "/usr/share/nginx/html:ro"
+
+	setupVolume(context.Background(), cli, volume, image, mountPath)
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volume, true)
+	}()
+
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("stream", "true")
+	q.Set("compression", "zstd")
+	q.Set("level", "19")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/export")
+	c.SetParamNames("volume")
+	c.SetParamValues(volume)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	// Export volume
+	err := h.ExportVolume(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/zstd", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, fmt.Sprintf("attachment; filename=%s.tar.zst", volume), rec.Header().Get(echo.HeaderContentDisposition))
+
+	// Check content of the response body is correct
+	dst := filepath.Join(os.TempDir(), "export-stream-level-destination")
+	defer func() {
+		if err = os.RemoveAll(dst); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if err := extractArchive(t, ".tar.zst", dst, rec.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join("testdata", "export", "vackup-volume")
+	for _, f := range []string{"50x.html", "index.html"} {
+		require.Equal(t, string(readFile(t, dir, f+".golden")), string(readFile(t, dst, f)))
+	}
+}
+
+func TestExportVolumeWithUnsupportedLevelShouldFail(t *testing.T) {
+	for _, level := range []string{"23", "-1", "fast"} {
+		t.Run(level, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("stream", "true")
+			q.Set("compression", "zstd")
+			q.Set("level", level)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export")
+			c.SetParamNames("volume")
+			c.SetParamValues("volume")
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, fmt.Errorf("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Export volume
+			err := h.ExportVolume(c)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestExportVolumeWithHostileInputShouldFail(t *testing.T) {
+	tests := map[string]struct {
+		volume string
+		params map[string]string
+	}{
+		"volume with command":       {volume: "$(reboot)", params: map[string]string{"path": "/tmp", "fileName": "backup.tar.zst"}},
+		"volume with separator":     {volume: "volume;reboot", params: map[string]string{"path": "/tmp", "fileName": "backup.tar.zst"}},
+		"fileName with directory":   {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "../etc/backup.tar.zst"}},
+		"fileName with newline":     {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "backup\nreboot"}},
+		"fileName with option":      {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "-rf"}},
+		"relative path":             {volume: "volume", params: map[string]string{"path": "tmp", "fileName": "backup.tar.zst"}},
+		"path with parent":          {volume: "volume", params: map[string]string{"path": "/tmp/../etc", "fileName": "backup.tar.zst"}},
+		"path with bind options":    {volume: "volume", params: map[string]string{"path": "/etc:/vackup-volume", "fileName": "backup.tar.zst"}},
+		"snapshot with directory":   {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "backup.tar.zst", "incremental": "true", "snapshot": "/etc/passwd"}},
+		"include with newline":      {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "backup.tar.zst", "include": "data\nreboot"}},
+		"stream volume with quote":  {volume: "volume'", params: map[string]string{"stream": "true"}},
+		"stream volume with option": {volume: "-rf", params: map[string]string{"stream": "true"}},
+		"path with command":         {volume: "volume", params: map[string]string{"path": "/tmp/$(reboot)/..", "fileName": "backup.tar.zst"}},
+		"fileName with command":     {volume: "volume", params: map[string]string{"path": "/tmp", "fileName": "$(reboot)/backup.tar.zst"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			for k, v := range tt.params {
+				q.Set(k, v)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export")
+			c.SetParamNames("volume")
+			c.SetParamValues(tt.volume)
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, fmt.Errorf("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Export volume
+			err := h.ExportVolume(c)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestExportVolumeStreamWithFilters(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumeID := "f1e2d3c4b5a6978877665544332211ffeeddccbbaa99887766554433221100ff"
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	runInVolume(t, cli, volumeID, "mkdir -p /data/app/logs /data/cache && "+
+		"echo app > /data/app/main.txt && echo log > /data/app/logs/app.log && "+
+		"echo cache > /data/cache/blob && echo secret > /data/secret.txt && "+
+		"echo secret.txt > /data/.backupignore")
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+		absent   []string
+	}{
+		{
+			name:     "exclude",
+			exclude:  []string{"cache", "*.log"},
+			expected: []string{".backupignore", "app/main.txt"},
+			absent:   []string{"cache/blob", "app/logs/app.log", "secret.txt"},
+		},
+		{
+			name:     "include",
+			include:  []string{"app"},
+			exclude:  []string{"logs"},
+			expected: []string{"app/main.txt"},
+			absent:   []string{".backupignore", "cache/blob", "app/logs/app.log", "secret.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("stream", "true")
+			for _, pattern := range tt.include {
+				q.Add("include", pattern)
+			}
+			for _, pattern := range tt.exclude {
+				q.Add("exclude", pattern)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export")
+			c.SetParamNames("volume")
+			c.SetParamValues(volumeID)
+			h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+			// Export volume
+			err := h.ExportVolume(c)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			// Check only the selected files are in the archive
+			dst := filepath.Join(os.TempDir(), "export-stream-filters-destination-"+tt.name)
+			defer func() {
+				if err = os.RemoveAll(dst); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			if err := extractArchive(t, ".tar.zst", dst, rec.Body); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, f := range tt.expected {
+				require.FileExists(t, filepath.Join(dst, f))
+			}
+			for _, f := range tt.absent {
+				require.NoFileExists(t, filepath.Join(dst, f))
+			}
+		})
+	}
+}
+
+func TestExportVolumeIncremental(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volume := "b8d0b4e0a1f64c2e9d3a7c5b1e8f6a2d4c0b9e7f5a3d1c8b6e4f2a0d9c7b5e3f"
+	restoredVolume := volume + "-restored"
+	image := "docker.io/library/nginx:1.21"
+	mountPath := "/usr/share/nginx/html:ro"
+
+	setupVolume(context.Background(), cli, volume, image, mountPath)
+
+	tmpDir, err := os.MkdirTemp("", "export-incremental")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+		_ = cli.VolumeRemove(context.Background(), volume, true)
+		_ = cli.VolumeRemove(context.Background(), restoredVolume, true)
+	}()
+
+	exportIncremental := func(fileName string) {
+		e := echo.New()
+		q := make(url.Values)
+		q.Set("path", tmpDir)
+		q.Set("fileName", fileName)
+		q.Set("incremental", "true")
+		req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/volumes/:volume/export")
+		c.SetParamNames("volume")
+		c.SetParamValues(volume)
+		h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+		err := h.ExportVolume(c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	// Base export contains every file of the volume
+	exportIncremental("base.tar.zst")
+	require.FileExists(t, filepath.Join(tmpDir, volume+".snar"))
+
+	// Delete a file and add a new one
+	runInVolume(t, cli, volume, "rm /data/50x.html && echo hello > /data/hello.txt")
+
+	// Incremental export only contains the changes
+	exportIncremental("incremental-1.tar.zst")
+
+	// Restore the base archive and the chain of incrementals into a new volume
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", filepath.Join(tmpDir, "base.tar.zst"))
+	q.Add("incremental", filepath.Join(tmpDir, "incremental-1.tar.zst"))
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(restoredVolume)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	err = h.ImportTarGzFile(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Check the deleted file is gone and the new file exists
+	runInVolume(t, cli, restoredVolume, "test ! -e /data/50x.html && test -e /data/index.html && grep -q hello /data/hello.txt")
+}
+
 func untar(t *testing.T, dst string, input io.Reader) error {
 	t.Helper()
 
@@ -238,6 +589,42 @@ func export(cli *client.Client, volume, path, compression string) *httptest.Resp
 	return rec
 }
 
+// runInVolume runs a shell command in a busybox container with the volume mounted at /data, and fails the test if the command fails.
+func runInVolume(t *testing.T, cli *client.Client, volumeID, cmd string) {
+	t.Helper()
+
+	resp, err := cli.ContainerCreate(context.Background(), &container.Config{
+		Image: "docker.io/library/busybox",
+		Cmd:   []string{"/bin/sh", "-c", cmd},
+	}, &container.HostConfig{
+		Binds: []string{
+			volumeID + ":" + "/data",
+		},
+	}, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+	}()
+
+	if err := cli.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	statusCh, errCh := cli.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case status := <-statusCh:
+		require.Equal(t, int64(0), status.StatusCode, "command %q failed in volume %s", cmd, volumeID)
+	}
+}
+
 // setupVolume creates a volume and fills it with data from an image.
 func setupVolume(ctx context.Context, cli *client.Client, volumeID, image, mountPath string) {
 	// Create volume
@@ -2,20 +2,26 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net/http"
 	"os"
 	"runtime"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/log"
+	"github.com/labstack/echo/v4"
 	"golang.org/x/sync/errgroup"
 )
 
 type Handler struct {
 	DockerClient  func() (*client.Client, error)
 	ProgressCache *ProgressCache
+	// Catalog lists the backups made by the extension. The catalog of New keeps them in memory only.
+	Catalog *backend.Catalog
 }
 
 func New(ctx context.Context, cliFactory func() (*client.Client, error)) *Handler {
@@ -28,8 +34,9 @@ func New(ctx context.Context, cliFactory func() (*client.Client, error)) *Handle
 	return &Handler{
 		DockerClient: cliFactory,
 		ProgressCache: &ProgressCache{
-			m: make(map[string]string),
+			m: make(map[string]*backend.Progress),
 		},
+		Catalog: backend.NewCatalog(""),
 	}
 }
 
@@ -66,3 +73,13 @@ func pullImagesIfNotPresent(ctx context.Context, cli *client.Client) {
 		log.Info("Successfully pulled all the images")
 	}
 }
+
+// requireHelperCommand responds with 501 Not Implemented if err is a backend.MissingCommandError,
+// e.g. the compression program of an archive is not installed in the helper image, or returns err otherwise.
+func requireHelperCommand(ctx echo.Context, err error) error {
+	var missingErr *backend.MissingCommandError
+	if errors.As(err, &missingErr) {
+		return ctx.String(http.StatusNotImplemented, err.Error())
+	}
+	return err
+}
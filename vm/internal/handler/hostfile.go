@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+// readHostFile returns the content of a file located in the host.
+func readHostFile(ctx context.Context, cli *client.Client, path string) ([]byte, error) {
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctx, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = backend.StreamFromContainer(ctx, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   []string{"cat", "/vackup-file"},
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "read-file",
+		},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup-file", ReadOnly: true},
+		},
+	}, &out)
+
+	return out.Bytes(), err
+}
+
+// peekHostFile returns the first n bytes of a file located in the host, e.g. to detect the format of an archive before importing it.
+// The alpine-tar-zstd image must be present.
+func peekHostFile(ctx context.Context, cli *client.Client, path string, n int) ([]byte, error) {
+	var out bytes.Buffer
+	err := backend.StreamFromContainer(ctx, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   []string{"head", "-c", strconv.Itoa(n), "/vackup-file"},
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "read-file",
+		},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup-file", ReadOnly: true},
+		},
+	}, &out)
+
+	return out.Bytes(), err
+}
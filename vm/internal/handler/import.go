@@ -1,42 +1,124 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"runtime"
+	"strings"
+	"unicode"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 
 	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/crypt"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
+// ImportTarGzFile imports the archive located in the host at "path" into a volume.
+// By default, the content of the volume is replaced. The "strategy" query parameter can be set to "merge" to keep the content of the volume
+// and overwrite only the files present in the archive, or to "skip-existing" to never overwrite a file of the volume.
+// To restore a chain of incremental exports, "path" must be the base (full) archive and every incremental archive
+// must be given, in the order they were exported, with the "incremental" query parameter.
+// To restore only some files or directories, their paths relative to the root of the volume must be given with the "entry" query parameter,
+// e.g. "etc/nginx". The entries are extracted on top of the current content of the volume, which is not removed.
+// Entries can also be restored from encrypted archives, as long as they were exported by the extension.
+// Encrypted archives are decrypted with the passphrase sent in the X-Vackup-Passphrase header or the content of the host file "keyFile".
+// If "dryRun" is true, the volume is left untouched and the response is a JSON report of the files that would be added, modified and deleted.
+// The archive is first extracted into a staging volume while the containers using the volume keep running, and the volume is only modified,
+// with the containers stopped, once the whole archive was extracted. An archive that cannot be extracted leaves the volume untouched
+// and the response is 422 Unprocessable Entity.
+// If "safetySnapshot" is true, the volume is copied into a snapshot volume before the import and restored from it if the import fails,
+// in which case the response is a JSON RestoreError that tells whether the rollback happened.
+// If the volume does not exist, it is created with the driver, the driver options that are safe to apply (see backend.SafeDriverOpts) and the labels of the metadata file
+// written next to the archive when it was exported, if any.
+// To import an archive split into parts, "path" must be its index ("<fileName>.index") or one of its parts ("<fileName>.part001").
+// The index is required: the parts must be exactly the ones it lists and match their checksums before they are reassembled and extracted,
+// otherwise the response is 422 Unprocessable Entity.
 func (h *Handler) ImportTarGzFile(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
 	path := ctx.QueryParam("path")
+	incrementals := ctx.QueryParams()["incremental"]
+	entries := ctx.QueryParams()["entry"]
+	strategyName := ctx.QueryParam("strategy")
+	dryRun := ctx.QueryParam("dryRun") == "true"
+	safetySnapshot := ctx.QueryParam("safetySnapshot") == "true"
 
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	if path == "" {
 		return ctx.String(http.StatusBadRequest, "path is required")
 	}
+	for _, p := range append([]string{path}, incrementals...) {
+		if err := backend.ValidateHostPath(p); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+	}
+	if len(entries) > 0 && len(incrementals) > 0 {
+		return ctx.String(http.StatusBadRequest, "entries cannot be restored from incremental archives")
+	}
+	if err := validateEntries(entries); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	strategy, err := backend.ParseImportStrategy(strategyName)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if len(incrementals) > 0 && strategy != backend.ImportStrategyReplace {
+		// Extracting an incremental archive removes the files that are not part of the snapshot
+		return ctx.String(http.StatusBadRequest, "incremental archives can only be imported with the replace strategy")
+	}
+	if dryRun && len(incrementals) > 0 {
+		return ctx.String(http.StatusBadRequest, "dry run is not supported for incremental archives")
+	}
+	partsDir, partsSep, partsFileName, multiPart := multiPartArchive(path)
+	if multiPart && partsSep == "" {
+		return ctx.String(http.StatusBadRequest, "path of a multi-part archive must be absolute")
+	}
+	if multiPart && (dryRun || len(incrementals) > 0) {
+		return ctx.String(http.StatusBadRequest, "multi-part archives cannot be imported with incremental archives or as a dry run")
+	}
 
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("path: %s", path)
+	log.Infof("incrementals: %+v", incrementals)
+	log.Infof("entries: %+v", entries)
+	log.Infof("strategy: %s", strategy)
+	log.Infof("dryRun: %t", dryRun)
+	log.Infof("safetySnapshot: %t", safetySnapshot)
+	log.Infof("multiPart: %t", multiPart)
 
 	cli, err := h.DockerClient()
 	if err != nil {
 		return err
 	}
 
+	if dryRun {
+		return h.importDryRun(ctx, cli, volumeName, path, entries, strategy)
+	}
+
+	// The manifest and the metadata of a multi-part archive are written next to its parts, without the part extension
+	archive := path
+	if multiPart {
+		archive = partsDir + partsSep + partsFileName
+	}
+
 	defer func() {
 		h.ProgressCache.Lock()
 		delete(h.ProgressCache.m, volumeName)
@@ -44,26 +126,32 @@ func (h *Handler) ImportTarGzFile(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("import", archiveSize(ctxReq, cli, archive))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "import"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
 		return err
 	}
 
-	// Stop container(s)
-	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
+	secret, err := secretFromRequest(ctx, cli)
 	if err != nil {
 		return err
 	}
-
-	// Import
-	binds := []string{
-		volumeName + ":" + "/vackup-volume",
-		path + ":" + "/vackup",
+	if secret != nil {
+		if len(incrementals) > 0 {
+			return ctx.String(http.StatusBadRequest, "incremental archives cannot be encrypted")
+		}
+		if multiPart {
+			return ctx.String(http.StatusBadRequest, "multi-part archives cannot be encrypted")
+		}
+		return h.importEncrypted(ctx, cli, volumeName, path, entries, strategy, safetySnapshot, secret)
 	}
-	log.Infof("binds: %+v", binds)
 
 	// Ensure the image is present before creating the container
 	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
@@ -77,22 +165,74 @@ func (h *Handler) ImportTarGzFile(ctx echo.Context) error {
 		return err
 	}
 
-	// remove hidden and not-hidden files and folders:
-	// ..?* matches all dot-dot files except '..'
-	// .[!.]* matches all dot files except '.' and files whose name begins with '..'
-	rmCmd := "rm -rf /vackup-volume/..?* /vackup-volume/.[!.]* /vackup-volume/*"
+	// Detect the compression of every archive from its first bytes before the volume is emptied,
+	// so that an encrypted or unsupported archive leaves the volume untouched.
+	archives := append([]string{path}, incrementals...)
+	if multiPart {
+		// The first part holds the beginning of the archive
+		archives[0] = partsDir + partsSep + partsFileName + partExt + "001"
+	}
+	// tar is told explicitly which program decompresses each archive, instead of guessing it from the file extension
+	decompressPrograms := make([]string, len(archives))
+	for i, archive := range archives {
+		header, err := peekHostFile(ctxReq, cli, archive, 512)
+		if err != nil {
+			if strings.Contains(err.Error(), "bind source path does not exist") {
+				return ctx.String(http.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		if crypt.IsEncrypted(header) {
+			return ctx.String(http.StatusBadRequest, "archive is encrypted, a passphrase or a key file is required")
+		}
 
-	// For backwards compatibility with version 1.0.0 of the extension, we check if the archive contains a root folder named "vackup-volume"
-	// If so, we use the "--strip-components=1" flag to decompress the **content** of the root folder (instead of the copying the root folder itself too).
-	// tar accepts "-a" to auto-detect the compression format (.tar.gz, .tar.zst or .tar.bz2).
-	fullCmd := fmt.Sprintf("%s && if [[ \"$(tar -tf /vackup vackup-volume/)\" ]]; then tar -axvf /vackup --strip-components=1 -C /vackup-volume; else tar -axvf /vackup -C /vackup-volume; fi", rmCmd)
-	log.Infof("fullCmd: %s", fullCmd)
-
-	resp, err := cli.ContainerCreate(ctxReq, &container.Config{
-		Image:        internal.AlpineTarZstdImage,
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", fullCmd},
+		compression, err := backend.DetectCompression(header)
+		if err != nil {
+			return ctx.String(http.StatusUnsupportedMediaType, fmt.Sprintf("%s: %s", archive, err))
+		}
+		log.Infof("compression of %s: %s", archive, compression.Name)
+
+		// The helper image might lack the decompression program
+		if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, compression.Program); err != nil {
+			return requireHelperCommand(ctx, err)
+		}
+		decompressPrograms[i] = compression.Program
+	}
+
+	// A volume that does not exist yet is created as it was when the archive was exported
+	if err := createVolumeFromArchiveMetadata(ctxReq, cli, volumeName, archive); err != nil {
+		return err
+	}
+
+	// The archive is extracted into a staging volume while the containers are still running,
+	// and the volume is only modified once the archive could be read until the end.
+	staging, err := backend.CreateStagingVolume(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+	log.Infof("staging: %s", staging)
+	defer func() {
+		if err := cli.VolumeRemove(context.Background(), staging, true); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	// Extract
+	binds := []string{
+		staging + ":" + "/vackup-volume",
+		path + ":" + "/vackup",
+	}
+	if multiPart {
+		// The whole directory of the parts is mounted, and the parts are concatenated in order
+		binds[1] = partsDir + ":" + "/vackup-parts"
+	}
+	for i, incremental := range incrementals {
+		binds = append(binds, incremental+":"+incrementalMountPath(i))
+	}
+	log.Infof("binds: %+v", binds)
+
+	config := &container.Config{
+		Image: internal.AlpineTarZstdImage,
 		Labels: map[string]string{
 			"com.docker.desktop.extension":        "true",
 			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
@@ -101,53 +241,331 @@ func (h *Handler) ImportTarGzFile(ctx echo.Context) error {
 			"com.volumes-backup-extension.volume": volumeName,
 			"com.volumes-backup-extension.path":   path,
 		},
-	}, &container.HostConfig{
+	}
+	hostConfig := &container.HostConfig{
 		Binds: binds,
-	}, nil, nil, "")
-	if err != nil {
-		return err
 	}
 
-	if err := cli.ContainerStart(ctxReq, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return err
+	// The commands are run in order, each one in its own container
+	var cmds [][]string
+	switch {
+	case multiPart:
+		// The parts are concatenated in a pipe, which needs a shell
+		cmds = append(cmds, multiPartExtractCmd(decompressPrograms[0], entryMembers(entries)))
+		config.Env = append(config.Env, "VACKUP_PARTS="+partsFileName)
+	case len(incrementals) > 0:
+		// Archives created with "--listed-incremental" must be extracted with "--listed-incremental=/dev/null",
+		// so that tar also removes the files that were deleted between two incremental exports.
+		// The base archive is extracted first, then every incremental archive is applied in order on top of it.
+		cmds = append(cmds, tarExtractCmd(decompressPrograms[0], "/vackup", "--listed-incremental=/dev/null"))
+		for i := range incrementals {
+			cmds = append(cmds, tarExtractCmd(decompressPrograms[i+1], incrementalMountPath(i), "--listed-incremental=/dev/null"))
+		}
+	default:
+		layout, err := readArchiveLayout(ctxReq, cli, config, hostConfig, decompressPrograms[0])
+		var exitErr *backend.ExitError
+		if errors.As(err, &exitErr) {
+			return ctx.String(http.StatusUnprocessableEntity, "archive could not be read: "+strings.TrimSpace(exitErr.Error()))
+		}
+		if err != nil {
+			return err
+		}
+		log.Infof("layout: %+v", layout)
+		cmds = append(cmds, tarExtractCmd(decompressPrograms[0], "/vackup", layout.extractOpts(entryMembers(entries))...))
 	}
 
-	var exitCode int64
-	statusCh, errCh := cli.ContainerWait(ctxReq, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	for _, cmd := range cmds {
+		log.Infof("cmd: %v", cmd)
+		config.Cmd = cmd
+		err = backend.StreamFromContainer(ctxReq, cli, config, hostConfig, os.Stdout)
 		if err != nil {
-			return err
+			break
 		}
-	case status := <-statusCh:
-		log.Infof("status: %#+v\n", status)
-		exitCode = status.StatusCode
+	}
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "archive could not be extracted: "+strings.TrimSpace(exitErr.Error()))
+	}
+	if err != nil {
+		return err
 	}
 
-	out, err := cli.ContainerLogs(ctxReq, resp.ID, types.ContainerLogsOptions{ShowStdout: true})
+	return h.restoreVolume(ctx, cli, volumeName, safetySnapshot, http.StatusOK, nil, func() error {
+		// The progress reports the extraction of the archive, not the copy of the staging volume
+		return backend.CopyVolume(backend.WithProgress(ctxReq, nil), cli, staging, volumeName, stagingStrategy(strategy, entries))
+	})
+}
+
+// importEncrypted decrypts the archive located in the host at "path" and imports it into the volume.
+// The archive is read by a first container, decrypted by the backend and extracted by a second container.
+// The archive is decrypted and extracted into a staging volume before the containers are stopped, so a wrong key or a corrupt archive
+// leaves the volume untouched.
+func (h *Handler) importEncrypted(ctx echo.Context, cli *client.Client, volumeName, path string, entries []string, strategy backend.ImportStrategy, safetySnapshot bool, secret []byte) error {
+	ctxReq := ctx.Request().Context()
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
 	if err != nil {
 		return err
 	}
 
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, out)
+	pr, pw := io.Pipe()
+	readDone := make(chan error, 1)
+	go func() {
+		err := backend.StreamFromContainer(ctxReq, cli, &container.Config{
+			Image: internal.AlpineTarZstdImage,
+			Cmd:   []string{"cat", "/vackup"},
+			Labels: map[string]string{
+				"com.docker.desktop.extension":        "true",
+				"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+				"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+				"com.volumes-backup-extension.action": "import",
+				"com.volumes-backup-extension.volume": volumeName,
+				"com.volumes-backup-extension.path":   path,
+			},
+		}, &container.HostConfig{
+			Mounts: []mount.Mount{
+				{Type: mount.TypeBind, Source: path, Target: "/vackup", ReadOnly: true},
+			},
+		}, pw)
+
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
+		readDone <- err
+	}()
+	defer func() {
+		// Stop reading the archive if the import failed
+		_ = pr.Close()
+		<-readDone
+	}()
+
+	cr, err := crypt.NewReader(pr, secret)
+	if errors.Is(err, crypt.ErrWrongKey) || errors.Is(err, crypt.ErrNotEncrypted) {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		return err
 	}
 
-	if exitCode != 0 {
-		return ctx.String(http.StatusInternalServerError, fmt.Sprintf("container exited with status code %d\n", exitCode))
+	// Peek at the first bytes of the decrypted archive to detect the compression without consuming the stream
+	br := bufio.NewReader(cr)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	compression, err := backend.DetectCompression(header)
+	if err != nil {
+		return ctx.String(http.StatusUnsupportedMediaType, err.Error())
+	}
+	log.Infof("compression: %s", compression.Name)
+
+	// The helper image might lack the decompression program
+	if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, compression.Program); err != nil {
+		return requireHelperCommand(ctx, err)
+	}
+
+	// A volume that does not exist yet is created as it was when the archive was exported
+	if err := createVolumeFromArchiveMetadata(ctxReq, cli, volumeName, path); err != nil {
+		return err
 	}
 
-	err = cli.ContainerRemove(ctxReq, resp.ID, types.ContainerRemoveOptions{})
+	staging, err := backend.CreateStagingVolume(ctxReq, cli, volumeName)
 	if err != nil {
 		return err
 	}
+	log.Infof("staging: %s", staging)
+	defer func() {
+		if err := cli.VolumeRemove(context.Background(), staging, true); err != nil {
+			log.Error(err)
+		}
+	}()
 
-	// Start container(s)
-	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	// The staging volume is empty, so there is nothing to remove or to skip
+	err = extractIntoVolume(ctxReq, cli, staging, br, compression.Program, entries, backend.ImportStrategyMerge)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "archive could not be extracted: "+strings.TrimSpace(exitErr.Error()))
+	}
 	if err != nil {
 		return err
 	}
 
-	return ctx.String(http.StatusOK, "")
+	return h.restoreVolume(ctx, cli, volumeName, safetySnapshot, http.StatusOK, nil, func() error {
+		// The progress reports the extraction of the archive, not the copy of the staging volume
+		return backend.CopyVolume(backend.WithProgress(ctxReq, nil), cli, staging, volumeName, stagingStrategy(strategy, entries))
+	})
+}
+
+// stagingStrategy returns the strategy used to copy the content of the staging volume into the volume.
+// Restoring entries never removes the other files of the volume.
+func stagingStrategy(strategy backend.ImportStrategy, entries []string) backend.ImportStrategy {
+	if len(entries) > 0 && strategy == backend.ImportStrategyReplace {
+		return backend.ImportStrategyMerge
+	}
+	return strategy
+}
+
+// incrementalMountPath returns the path where the i-th incremental archive of a chain is mounted in the container.
+func incrementalMountPath(i int) string {
+	return fmt.Sprintf("/vackup-incremental-%03d", i+1)
+}
+
+// tarExtractCmd returns the command that extracts the archive mounted at the path into /vackup-volume, with the listing of the files
+// printed on stderr for the progress. The archive is decompressed with decompressProgram, if not empty.
+func tarExtractCmd(decompressProgram, archive string, opts ...string) []string {
+	cmd := []string{"tar"}
+	if decompressProgram != "" {
+		cmd = append(cmd, "-I", decompressProgram)
+	}
+	cmd = append(cmd, backend.ProgressTarArgs...)
+	cmd = append(cmd, "-xf", archive, "-C", "/vackup-volume")
+	return append(cmd, opts...)
+}
+
+// multiPartExtractCmd returns the command that checks the parts of the multi-part archive mounted at /vackup-parts against its index,
+// and extracts their concatenation into /vackup-volume. Only the given members are extracted, if any.
+// The name of the archive is read from the VACKUP_PARTS environment variable, and the members are passed as positional parameters.
+func multiPartExtractCmd(decompressProgram string, members []string) []string {
+	// A missing index, a missing, extra or corrupted part fails the import before anything is extracted.
+	// The parts matched by the glob must be exactly the ones listed in the index, after the 64 characters of the checksum and the 2 separators.
+	checkCmd := fmt.Sprintf(`(cd /vackup-parts && { [ -f "$VACKUP_PARTS"%[1]s ] || { echo "index $VACKUP_PARTS%[1]s not found" >&2; exit 1; }; } && `+
+		`{ [ "$(printf '%%s\n' %[2]s)" = "$(cut -c 67- "$VACKUP_PARTS"%[1]s)" ] || { echo "parts do not match the index $VACKUP_PARTS%[1]s" >&2; exit 1; }; } && `+
+		`sha256sum -c "$VACKUP_PARTS"%[1]s) || exit 1; `, partIndexExt, partsGlob(`"$VACKUP_PARTS"`))
+
+	tar := "tar "
+	if decompressProgram != "" {
+		tar += fmt.Sprintf("-I %q ", decompressProgram)
+	}
+	cat := fmt.Sprintf("cat %s | ", partsGlob(`"/vackup-parts/$VACKUP_PARTS"`))
+
+	// For backwards compatibility with version 1.0.0 of the extension, we check if the archive contains a root folder named "vackup-volume"
+	// If so, we use the "--strip-components=1" flag to decompress the **content** of the root folder (instead of the copying the root folder itself too).
+	if len(members) == 0 {
+		return backend.ShellCmd(fmt.Sprintf(`%[1]sif [[ "$(%[2]s%[3]s-tf - vackup-volume/)" ]]; then %[2]s%[3]s%[4]s -xf - --strip-components=1 -C /vackup-volume; else %[2]s%[3]s%[4]s -xf - -C /vackup-volume; fi`,
+			checkCmd, cat, tar, backend.ProgressTarOpts))
+	}
+
+	// The members must be given to tar with the exact names of the archive members:
+	// archives exported by the extension store the files under "./", but other archives might not.
+	return backend.ShellCmd(checkCmd+fmt.Sprintf(`if [[ "$(%[1]s%[2]s-tf - vackup-volume/)" ]]; then %[3]s; %[1]s%[2]s%[5]s -xf - --strip-components=1 -C /vackup-volume -- "$@"; `+
+		`elif [ "$(%[1]s%[2]s-tf - | head -n 1 | cut -c 1-2)" = "./" ]; then %[4]s; %[1]s%[2]s%[5]s -xf - -C /vackup-volume -- "$@"; `+
+		`else %[1]s%[2]s%[5]s -xf - -C /vackup-volume -- "$@"; fi`,
+		cat, tar, prefixArgsCmd("vackup-volume/"), prefixArgsCmd("./"), backend.ProgressTarOpts), members...)
+}
+
+// archiveLayout is how the members of an archive are named.
+type archiveLayout struct {
+	// rootFolder is whether the members are under a root folder named "vackup-volume", as in the archives exported by version 1.0.0 of the extension.
+	rootFolder bool
+	// dotSlash is whether the members start with "./", as in the archives exported by the extension since then.
+	dotSlash bool
+}
+
+// extractOpts returns the options of tarExtractCmd that extract the given members of the archive, or all of them if there are none,
+// relative to the root of the volume.
+func (l archiveLayout) extractOpts(members []string) []string {
+	var opts []string
+	prefix := ""
+	switch {
+	case l.rootFolder:
+		// The content of the root folder is extracted, instead of the root folder itself too
+		opts, prefix = append(opts, "--strip-components=1"), "vackup-volume/"
+	case l.dotSlash:
+		prefix = "./"
+	}
+	if len(members) == 0 {
+		return opts
+	}
+	opts = append(opts, "--")
+	for _, member := range members {
+		opts = append(opts, prefix+member)
+	}
+	return opts
+}
+
+// readArchiveLayout lists the members of the archive mounted at /vackup, decompressed with decompressProgram if not empty,
+// in a container created from config and hostConfig, and returns their layout.
+func readArchiveLayout(ctx context.Context, cli *client.Client, config *container.Config, hostConfig *container.HostConfig, decompressProgram string) (archiveLayout, error) {
+	cmd := []string{"tar"}
+	if decompressProgram != "" {
+		cmd = append(cmd, "-I", decompressProgram)
+	}
+	cmd = append(cmd, "-tf", "/vackup")
+	log.Infof("cmd: %v", cmd)
+
+	listing := &layoutWriter{}
+	listingConfig := *config
+	listingConfig.Cmd = cmd
+	// The listing is not part of the progress of the import
+	if err := backend.StreamFromContainer(backend.WithProgress(ctx, nil), cli, &listingConfig, hostConfig, listing); err != nil {
+		return archiveLayout{}, err
+	}
+	return listing.layout, nil
+}
+
+// layoutWriter reads the names of the members of an archive written into it, one per line, into their layout.
+type layoutWriter struct {
+	layout archiveLayout
+	read   bool // whether the first member was read
+	line   []byte
+}
+
+func (w *layoutWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			w.line = append(w.line, b...)
+			break
+		}
+		w.member(append(w.line, b[:i]...))
+		w.line = w.line[:0]
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+func (w *layoutWriter) member(name []byte) {
+	if !w.read {
+		w.read = true
+		w.layout.dotSlash = bytes.HasPrefix(name, []byte("./"))
+	}
+	if bytes.HasPrefix(name, []byte("vackup-volume/")) {
+		w.layout.rootFolder = true
+	}
+}
+
+// validateEntries returns an error if one of the entries to restore is not a path inside the volume.
+func validateEntries(entries []string) error {
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" || path.Clean("/"+entry) == "/" {
+			return fmt.Errorf("invalid entry %q, it must be the path of a file or a directory in the volume", entry)
+		}
+		if strings.IndexFunc(entry, unicode.IsControl) != -1 {
+			return fmt.Errorf("invalid entry %q, it must not contain control characters", entry)
+		}
+	}
+	return nil
+}
+
+// entryMembers returns the names of the archive members to extract for the given entries, relative to the root of the volume.
+func entryMembers(entries []string) []string {
+	var members []string
+	for _, entry := range entries {
+		members = append(members, strings.TrimPrefix(path.Clean("/"+entry), "/"))
+	}
+	return members
+}
+
+// prefixArgsCmd returns a shell command that prepends prefix to every positional parameter of the script,
+// e.g. the root folder of the members in the archive: "./" for the archives exported by the extension
+// or "vackup-volume/" for the ones exported by version 1.0.0 of the extension.
+func prefixArgsCmd(prefix string) string {
+	return fmt.Sprintf(`for e do set -- "$@" %q"$e"; shift; done`, prefix)
 }
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
@@ -198,3 +201,367 @@ func TestImportTarGzFileShouldRemovePreviousVolumeData(t *testing.T) {
 	require.Equal(t, int64(16000), sizes[volumeID].Bytes)
 	require.Equal(t, "16.0 kB", sizes[volumeID].Human)
 }
+
+func TestImportTarGzFileEntries(t *testing.T) {
+	volumeID := "4b7e2d9c1a8f3e6b5d0c9a2f7e4b1d8c3a6f9e2b5d8c1a4f7e0b3d6c9a2f5e8b"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	fileName := "nginx.tar.gz"
+
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	absolutePath := filepath.Join(pwd, "testdata", "import", fileName)
+	q.Set("path", absolutePath)
+	q.Add("entry", "index.html")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	// Create volume
+	_, err = cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate volume
+	runInVolume(t, cli, volumeID, "echo broken > /data/index.html && echo keep > /data/other.txt")
+
+	// Import only index.html
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// index.html is restored, the other files of the volume are kept and the other files of the archive are not extracted
+	runInVolume(t, cli, volumeID, "grep -q nginx /data/index.html && grep -q keep /data/other.txt && test ! -e /data/50x.html")
+}
+
+func TestImportTarGzFileWithInvalidEntryShouldFail(t *testing.T) {
+	for _, entry := range []string{"", "/", "../..", "index.html\nreboot"} {
+		t.Run(entry, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", "/tmp/archive.tar.gz")
+			q.Add("entry", entry)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import")
+			c.SetParamNames("volume")
+			c.SetParamValues("volume")
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Import volume
+			err := h.ImportTarGzFile(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestImportTarGzFileWithHostileInputShouldFail(t *testing.T) {
+	tests := map[string]struct {
+		volume      string
+		path        string
+		incremental string
+	}{
+		"volume with command":      {volume: "$(reboot)", path: "/tmp/archive.tar.gz"},
+		"volume with separator":    {volume: "volume && reboot", path: "/tmp/archive.tar.gz"},
+		"relative path":            {volume: "volume", path: "archive.tar.gz"},
+		"path with parent":         {volume: "volume", path: "/tmp/../etc/shadow"},
+		"path with bind options":   {volume: "volume", path: "/tmp/archive.tar.gz:/vackup-volume"},
+		"path with newline":        {volume: "volume", path: "/tmp/archive.tar.gz\nreboot"},
+		"incremental with parent":  {volume: "volume", path: "/tmp/archive.tar.gz", incremental: "../archive.1.tar.gz"},
+		"multi-part with command":  {volume: "volume", path: "/tmp/$(reboot)/../archive.tar.gz.index"},
+		"multi-part with bind opt": {volume: "volume", path: "/tmp:/etc/archive.tar.gz.part001"},
+		"volume with option":       {volume: "-rf", path: "/tmp/archive.tar.gz"},
+		"path with command":        {volume: "volume", path: "/tmp/$(reboot)/../archive.tar.gz"},
+		"incremental with newline": {volume: "volume", path: "/tmp/archive.tar.gz", incremental: "/tmp/archive.1.tar.gz\nreboot"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", tt.path)
+			if tt.incremental != "" {
+				q.Add("incremental", tt.incremental)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import")
+			c.SetParamNames("volume")
+			c.SetParamValues(tt.volume)
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Import volume
+			err := h.ImportTarGzFile(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestImportTarGzFileStrategies(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	absolutePath := filepath.Join(pwd, "testdata", "import", "nginx.tar.gz")
+
+	tests := []struct {
+		strategy string
+		volumeID string
+		check    string
+	}{
+		{
+			strategy: "merge",
+			volumeID: "8c3f1e5a7b9d2c4e6f8a0b1c3d5e7f9a2b4c6d8e0f1a3b5c7d9e2f4a6b8c0d1e",
+			check:    "grep -q nginx /data/index.html && test -f /data/50x.html && grep -q keep /data/other.txt",
+		},
+		{
+			strategy: "skip-existing",
+			volumeID: "1d3f5b7a9c2e4f6a8b0c1d3e5f7a9b2c4d6e8f0a1b3c5d7e9f2a4b6c8d0e1f3a",
+			check:    "grep -q mine /data/index.html && test -f /data/50x.html && grep -q keep /data/other.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			defer func() {
+				_ = cli.VolumeRemove(context.Background(), tt.volumeID, true)
+			}()
+
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", absolutePath)
+			q.Set("strategy", tt.strategy)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import")
+			c.SetParamNames("volume")
+			c.SetParamValues(tt.volumeID)
+			h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+			// Create volume
+			_, err = cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+				Driver: "local",
+				Name:   tt.volumeID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Populate volume
+			runInVolume(t, cli, tt.volumeID, "echo mine > /data/index.html && echo keep > /data/other.txt")
+
+			// Import volume
+			err = h.ImportTarGzFile(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			runInVolume(t, cli, tt.volumeID, tt.check)
+		})
+	}
+}
+
+func TestImportTarGzFileWithUnknownStrategyShouldFail(t *testing.T) {
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", "/tmp/archive.tar.gz")
+	q.Set("strategy", "overwrite")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues("volume")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+	}
+
+	// Import volume
+	err := h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestImportTarGzFileDryRun(t *testing.T) {
+	volumeID := "5e7a9c1b3d5f7e9a2c4b6d8f0e1a3c5b7d9f2e4a6c8b0d1f3e5a7c9b2d4f6e8a"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	fileName := "nginx.tar.gz"
+
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	absolutePath := filepath.Join(pwd, "testdata", "import", fileName)
+	q.Set("path", absolutePath)
+	q.Set("dryRun", "true")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	// Create volume
+	_, err = cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate volume
+	runInVolume(t, cli, volumeID, "echo mine > /data/index.html && echo keep > /data/other.txt")
+
+	// Dry run the import
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var report backend.DiffReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Len(t, report.Added, 1)
+	require.Equal(t, "50x.html", report.Added[0].Path)
+	require.Equal(t, int64(497), report.AddedBytes)
+	require.Len(t, report.Modified, 1)
+	require.Equal(t, "index.html", report.Modified[0].Path)
+	require.Equal(t, int64(615), report.ModifiedBytes)
+	require.Len(t, report.Deleted, 1)
+	require.Equal(t, "other.txt", report.Deleted[0].Path)
+
+	// The volume is left untouched
+	runInVolume(t, cli, volumeID, "grep -q mine /data/index.html && grep -q keep /data/other.txt && test ! -e /data/50x.html")
+}
+
+func TestImportTarGzFileWithCorruptArchiveShouldLeaveVolumeUntouched(t *testing.T) {
+	volumeID := "9a1c3e5b7d9f1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f2a4c6e8b0d2f4a6c8e0b2d"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	// A gzip header followed by garbage: the compression is detected, but tar fails while extracting the archive
+	tmpDir, err := os.MkdirTemp("", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := filepath.Join(tmpDir, "corrupt.tar.gz")
+	if err := os.WriteFile(archive, append([]byte{0x1f, 0x8b, 0x08, 0x00}, []byte("this is not a gzip stream")...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", archive)
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	// Create volume
+	_, err = cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate volume
+	runInVolume(t, cli, volumeID, "echo keep > /data/data.txt")
+
+	// Import volume
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// The volume is left untouched and the staging volume is removed
+	runInVolume(t, cli, volumeID, "grep -q keep /data/data.txt")
+
+	stagings, err := cli.VolumeList(context.Background(), volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", backend.StagingLabel+"="+volumeID)),
+	})
+	require.NoError(t, err)
+	require.Empty(t, stagings.Volumes)
+}
+
+func TestArchiveLayoutExtractOpts(t *testing.T) {
+	tests := map[string]struct {
+		listing string
+		members []string
+		want    []string
+	}{
+		"extension":           {listing: "./\n./index.html\n", want: nil},
+		"extension entries":   {listing: "./\n./index.html\n", members: []string{"index.html", "$(reboot)"}, want: []string{"--", "./index.html", "./$(reboot)"}},
+		"version 1.0.0":       {listing: "vackup-volume/\nvackup-volume/index.html\n", want: []string{"--strip-components=1"}},
+		"version 1.0.0 entry": {listing: "vackup-volume/\nvackup-volume/index.html\n", members: []string{"-rf"}, want: []string{"--strip-components=1", "--", "vackup-volume/-rf"}},
+		"other entries":       {listing: "index.html\n", members: []string{"-rf"}, want: []string{"--", "-rf"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := &layoutWriter{}
+			// The listing is written in two parts, the lines must be read across writes
+			_, _ = w.Write([]byte(tt.listing[:3]))
+			_, _ = w.Write([]byte(tt.listing[3:]))
+
+			require.Equal(t, tt.want, w.layout.extractOpts(tt.members))
+		})
+	}
+}
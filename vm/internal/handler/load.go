@@ -1,34 +1,88 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"runtime"
+	"strings"
 
 	"github.com/bugsnag/bugsnag-go/v2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/docker/volumes-backup-extension/internal"
 	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
+// LoadImage copies the content of the /volume-data directory of the image "image" into a volume.
+// The image is never run, so it does not need a shell and it can be an image of another platform.
+// If "path" is set, the image is first loaded from the "docker save" tarball located in the host at "path", as with "docker load".
+// The tarball must hold a single image unless "image" tells which of its images to copy into the volume.
+// By default, the content of the volume is replaced, see ImportTarGzFile for the other values of the "strategy" query parameter.
+// If "dryRun" is true, the volume is left untouched and the response is a JSON report of the files that would be added, modified and deleted.
+// If "safetySnapshot" is true, the volume is restored from a snapshot taken before the load if the load fails, see ImportTarGzFile.
+// If the volume does not exist, it is created with the driver, the driver options that are safe to apply (see backend.SafeDriverOpts) and the labels of the volume the image was saved from.
+// The response is a JSON LoadResponse with the provenance of the image, and warnings if the volume differs from the volume
+// the image was saved from or if the content of the image changed since it was saved.
 func (h *Handler) LoadImage(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
 	image := ctx.QueryParam("image")
+	path := ctx.QueryParam("path")
+	strategyName := ctx.QueryParam("strategy")
+	dryRun := ctx.QueryParam("dryRun") == "true"
+	safetySnapshot := ctx.QueryParam("safetySnapshot") == "true"
 
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
-	if image == "" {
-		return ctx.String(http.StatusBadRequest, "image is required")
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if image == "" && path == "" {
+		return ctx.String(http.StatusBadRequest, "image or path is required")
+	}
+	if path != "" {
+		if err := backend.ValidateHostPath(path); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if dryRun {
+			// A dry run must leave the engine untouched as well
+			return ctx.String(http.StatusBadRequest, "dry run is not supported for image tarballs")
+		}
+	}
+	strategy, err := backend.ParseImportStrategy(strategyName)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
 	}
 
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("image: %s", image)
+	log.Infof("path: %s", path)
+	log.Infof("strategy: %s", strategy)
+	log.Infof("dryRun: %t", dryRun)
+	log.Infof("safetySnapshot: %t", safetySnapshot)
 
 	cli, err := h.DockerClient()
 	if err != nil {
 		return err
 	}
+
+	if dryRun {
+		return h.loadDryRun(ctx, cli, volumeName, image, strategy)
+	}
 	defer func() {
 		h.ProgressCache.Lock()
 		delete(h.ProgressCache.m, volumeName)
@@ -36,32 +90,180 @@ func (h *Handler) LoadImage(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	if path != "" {
+		// The size of the image is unknown until it is loaded
+		h.ProgressCache.Lock()
+		h.ProgressCache.m[volumeName] = backend.NewProgress("load", 0)
+		h.ProgressCache.Unlock()
+
+		if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+			return err
+		}
+
+		loaded, err := loadImageTarball(ctxReq, cli, path)
+		var jsonErr *jsonmessage.JSONError
+		if errors.As(err, &jsonErr) {
+			return ctx.String(http.StatusUnprocessableEntity, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		log.Infof("loaded images: %+v", loaded)
+
+		image, err = tarballImage(loaded, image)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("load", imageDataSize(ctxReq, cli, image))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "load"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
 		return err
 	}
 
-	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
+	response, err := imageProvenance(ctxReq, cli, volumeName, image)
 	if err != nil {
 		return err
 	}
 
-	// Load
-	err = backend.Load(ctxReq, cli, volumeName, image)
-	if err != nil {
+	// A volume that does not exist yet is created as it was when the image was saved
+	if err := createVolumeFromImageMetadata(ctxReq, cli, volumeName, image); err != nil {
 		return err
 	}
 
-	// Start container(s)
-	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	err = h.restoreVolume(ctx, cli, volumeName, safetySnapshot, http.StatusOK, response, func() error {
+		// Load
+		return backend.Load(ctxReq, cli, volumeName, image, strategy)
+	})
 	if err != nil {
 		log.Error(err)
 		_ = bugsnag.Notify(err, ctxReq)
+	}
+
+	return err
+}
+
+// loadImageTarball loads the images of the "docker save" tarball located in the host at path and returns their names,
+// or their IDs for the images without a name. The errors of the engine about the content of the tarball are *jsonmessage.JSONError.
+func loadImageTarball(ctx context.Context, cli *client.Client, path string) ([]string, error) {
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctx, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tarball is loaded while it is read from the host
+	pr, pw := io.Pipe()
+	var loaded []string
+	var loadErr error
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := backend.StreamFromContainer(gCtx, cli, &container.Config{
+			Image: internal.AlpineTarZstdImage,
+			Cmd:   []string{"cat", "/vackup-file"},
+			Labels: map[string]string{
+				"com.docker.desktop.extension":        "true",
+				"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+				"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+				"com.volumes-backup-extension.action": "load",
+				"com.volumes-backup-extension.path":   path,
+			},
+		}, &container.HostConfig{
+			Mounts: []mount.Mount{
+				{Type: mount.TypeBind, Source: path, Target: "/vackup-file", ReadOnly: true},
+			},
+		}, pw)
+
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
 		return err
+	})
+	g.Go(func() error {
+		resp, err := cli.ImageLoad(gCtx, pr, true)
+		if err == nil {
+			loaded, loadErr = loadedImages(resp.Body)
+			err = loadErr
+			_ = resp.Body.Close()
+		}
+
+		// Stop reading the tarball if it can't be loaded
+		_ = pr.CloseWithError(err)
+		return err
+	})
+	err = g.Wait()
+	if loadErr != nil {
+		// The error of the engine is more telling than the error of the reading of the tarball it caused
+		return loaded, loadErr
+	}
+
+	return loaded, err
+}
+
+// loadedImages returns the images listed in the response of the engine to "docker load", e.g. "Loaded image: db:v1".
+func loadedImages(r io.Reader) ([]string, error) {
+	var loaded []string
+
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if msg.Error != nil {
+			return loaded, msg.Error
+		}
+
+		line := strings.TrimSpace(msg.Stream)
+		for _, prefix := range []string{"Loaded image: ", "Loaded image ID: "} {
+			if strings.HasPrefix(line, prefix) {
+				loaded = append(loaded, strings.TrimPrefix(line, prefix))
+			}
+		}
+	}
+}
+
+// tarballImage returns the image of a tarball to load into the volume: image, which must be one of the loaded images,
+// or the only loaded image if image is empty.
+func tarballImage(loaded []string, image string) (string, error) {
+	if image == "" {
+		if len(loaded) != 1 {
+			return "", fmt.Errorf("the tarball holds %d images instead of one, the image to load must be chosen among: %s", len(loaded), strings.Join(loaded, ", "))
+		}
+		return loaded[0], nil
 	}
 
-	return ctx.String(http.StatusOK, "")
+	for _, l := range loaded {
+		if l == image || normalizedImage(l) == normalizedImage(image) {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("image %s is not in the tarball, which holds: %s", image, strings.Join(loaded, ", "))
+}
+
+// normalizedImage returns the full name of the image, e.g. "docker.io/library/db:latest" for "db", or image if it is not a valid name.
+func normalizedImage(image string) string {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return reference.TagNameOnly(ref).String()
 }
@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,12 +12,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 
@@ -177,3 +182,176 @@ func TestLoadImageShouldRemovePreviousVolumeData(t *testing.T) {
 	require.Equal(t, int64(16000), sizes[volumeID].Bytes)
 	require.Equal(t, "16.0 kB", sizes[volumeID].Human)
 }
+
+func TestLoadImageWithoutShellOfAnotherPlatform(t *testing.T) {
+	volumeID := "8a0c2e4b6d8f0a2c4e6b8d0f2a4c6e8b0d2f4a6c8e0b2d4f6a8c0e2b4d6f8a0c"
+	imageID := "vackup-load-test-foreign-img:latest"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_, _ = cli.ImageRemove(context.Background(), imageID, types.ImageRemoveOptions{Force: true})
+	}()
+
+	// An image with only a /volume-data directory, built for another architecture than the engine's
+	arch := "s390x"
+	if runtime.GOARCH == arch {
+		arch = "amd64"
+	}
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "volume-data/", Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "volume-data/hello.txt", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	resp, err := cli.ImageImport(context.Background(), types.ImageImportSource{Source: &layer, SourceName: "-"}, imageID, types.ImageImportOptions{
+		Platform: "linux/" + arch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(os.Stdout, resp)
+	require.NoError(t, err)
+	_ = resp.Close()
+
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("image", imageID)
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/load")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	// Load image into volume
+	err = h.LoadImage(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	runInVolume(t, cli, volumeID, "grep -q hello /data/hello.txt")
+}
+
+func TestLoadImageFromTarball(t *testing.T) {
+	volumeID := "9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a5c7e9b1d"
+	loaded := "0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e"
+	image := "vackup-tarball-test-img:latest"
+	cli := setupDockerClient(t)
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_ = cli.VolumeRemove(context.Background(), loaded, true)
+		_, _ = cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true})
+	}()
+	runInVolume(t, cli, volumeID, "echo hello > /data/hello.txt")
+
+	// Write the tarball of the image, as with "docker save", and remove the image
+	_, err = backend.Save(context.Background(), cli, volumeID, image, "", backend.Filter{})
+	require.NoError(t, err)
+	saved, err := cli.ImageSave(context.Background(), []string{image})
+	require.NoError(t, err)
+	tmpDir, err := os.MkdirTemp("", "tarball")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	f, err := os.Create(filepath.Join(tmpDir, "image.tar"))
+	require.NoError(t, err)
+	_, err = io.Copy(f, saved)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_ = saved.Close()
+	_, err = cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true})
+	require.NoError(t, err)
+
+	// Load
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", filepath.Join(tmpDir, "image.tar"))
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/load")
+	c.SetParamNames("volume")
+	c.SetParamValues(loaded)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	err = h.LoadImage(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	runInVolume(t, cli, loaded, "grep -q hello /data/hello.txt")
+}
+
+func TestLoadImageWithInvalidInputShouldFail(t *testing.T) {
+	tests := map[string]url.Values{
+		"no image":         {},
+		"relative path":    {"path": {"image.tar"}},
+		"path":             {"path": {"/tmp/../etc/image.tar"}},
+		"dry run":          {"path": {"/tmp/image.tar"}, "dryRun": {"true"}},
+		"unknown strategy": {"image": {"db:latest"}, "strategy": {"overwrite"}},
+	}
+
+	for name, q := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/load")
+			c.SetParamNames("volume")
+			c.SetParamValues("db")
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			err := h.LoadImage(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestLoadedImages(t *testing.T) {
+	loaded, err := loadedImages(strings.NewReader(`{"stream":"Loaded image: db:v1\n"}
+{"stream":"Loaded image ID: sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b\n"}
+`))
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"db:v1", "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"}, loaded)
+
+	_, err = loadedImages(strings.NewReader(`{"errorDetail":{"message":"unexpected EOF"},"error":"unexpected EOF"}`))
+
+	var jsonErr *jsonmessage.JSONError
+	require.ErrorAs(t, err, &jsonErr)
+}
+
+func TestTarballImage(t *testing.T) {
+	image, err := tarballImage([]string{"db:v1"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "db:v1", image)
+
+	image, err = tarballImage([]string{"db:v1", "docker.io/acme/cache:latest"}, "acme/cache")
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/acme/cache:latest", image)
+
+	_, err = tarballImage([]string{"db:v1", "db:v2"}, "")
+	require.Error(t, err)
+
+	_, err = tarballImage([]string{"db:v1"}, "db:v2")
+	require.Error(t, err)
+}
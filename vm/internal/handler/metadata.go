@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/client"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// createVolumeFromArchiveMetadata creates the volume with the metadata written next to the archive located in the host at "archive",
+// if the volume does not exist yet. Without metadata, the volume is left to be created with the default driver when it is mounted.
+func createVolumeFromArchiveMetadata(ctx context.Context, cli *client.Client, volumeName, archive string) error {
+	_, err := cli.VolumeInspect(ctx, volumeName)
+	if err == nil || !client.IsErrNotFound(err) {
+		return err
+	}
+
+	data, err := readHostFile(ctx, cli, archive+backend.MetadataExt)
+	if err != nil {
+		if strings.Contains(err.Error(), "bind source path does not exist") {
+			// The archive was not exported by the extension, or by a version that did not write the metadata
+			return nil
+		}
+		return err
+	}
+
+	metadata, err := backend.ParseVolumeMetadata(data)
+	if err != nil {
+		log.Warnf("ignoring the metadata of %s: %s", archive, err)
+		return nil
+	}
+
+	_, err = backend.CreateVolumeIfNotExists(ctx, cli, volumeName, metadata)
+	return err
+}
+
+// createVolumeFromImageMetadata creates the volume with the metadata stored in the labels of the image, if the volume does not exist yet.
+// Without metadata, the volume is left to be created with the default driver when it is mounted.
+func createVolumeFromImageMetadata(ctx context.Context, cli *client.Client, volumeName, image string) error {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return err
+	}
+	if inspect.Config == nil || inspect.Config.Labels[backend.MetadataLabel] == "" {
+		// The image was not saved by the extension, or by a version that did not store the metadata
+		return nil
+	}
+
+	metadata, err := backend.ParseVolumeMetadata([]byte(inspect.Config.Labels[backend.MetadataLabel]))
+	if err != nil {
+		log.Warnf("ignoring the metadata of %s: %s", image, err)
+		return nil
+	}
+
+	_, err = backend.CreateVolumeIfNotExists(ctx, cli, volumeName, metadata)
+	return err
+}
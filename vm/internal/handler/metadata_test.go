@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestImportTarGzFileShouldCreateVolumeWithMetadata(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumeID := "6e8a0c2e4b6d8f0b2d4f6a8c0e2b4d6f8a0c2e4b6d8f0a2c4e6b8d0f2a4c6e8a"
+	createOptions := volume.CreateOptions{
+		Driver:     "local",
+		DriverOpts: map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=10m"},
+		Labels:     map[string]string{"com.docker.compose.project": "shop"},
+		Name:       volumeID,
+	}
+	_, err := cli.VolumeCreate(context.Background(), createOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+	runInVolume(t, cli, volumeID, "echo hello > /data/hello.txt")
+
+	tmpDir, err := os.MkdirTemp("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	// Export volume
+	rec := export(cli, volumeID, tmpDir, ".tar.zst")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.FileExists(t, filepath.Join(tmpDir, volumeID+".tar.zst"+backend.MetadataExt))
+
+	// Remove volume
+	require.NoError(t, cli.VolumeRemove(context.Background(), volumeID, true))
+
+	// Import volume
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", filepath.Join(tmpDir, volumeID+".tar.zst"))
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	v, err := cli.VolumeInspect(context.Background(), volumeID)
+	require.NoError(t, err)
+	require.Equal(t, createOptions.Driver, v.Driver)
+	require.Equal(t, createOptions.DriverOpts, v.Options)
+	require.Equal(t, "shop", v.Labels["com.docker.compose.project"])
+	runInVolume(t, cli, volumeID, "grep -q hello /data/hello.txt")
+}
+
+func TestLoadImageShouldCreateVolumeWithMetadata(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumeID := "7f9b1d3f5c7e9a1c3e5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d"
+	image := "vackup-metadata-test-img:latest"
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Labels: map[string]string{"com.docker.compose.project": "shop"},
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_, _ = cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true})
+	}()
+	runInVolume(t, cli, volumeID, "echo hello > /data/hello.txt")
+
+	// Save volume
+	_, err = backend.Save(context.Background(), cli, volumeID, image, "", backend.Filter{})
+	require.NoError(t, err)
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	require.NoError(t, err)
+	metadata, err := backend.ParseVolumeMetadata([]byte(inspect.Config.Labels[backend.MetadataLabel]))
+	require.NoError(t, err)
+	require.Equal(t, "shop", metadata.Labels["com.docker.compose.project"])
+
+	// Remove volume
+	require.NoError(t, cli.VolumeRemove(context.Background(), volumeID, true))
+
+	// Load image
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("image", image)
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/load")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	err = h.LoadImage(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	v, err := cli.VolumeInspect(context.Background(), volumeID)
+	require.NoError(t, err)
+	require.Equal(t, "shop", v.Labels["com.docker.compose.project"])
+	runInVolume(t, cli, volumeID, "grep -q hello /data/hello.txt")
+}
@@ -0,0 +1,521 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// ociTarballExt is the extension of the file names of the OCI image layouts written or read as a tarball instead of a directory.
+const ociTarballExt = ".tar"
+
+// ExportOCI exports the content of the volume as an OCI image layout, i.e. the "oci-layout" and "index.json" files and the "blobs" directory,
+// written to the host directory "path" as "fileName". The layout is written as a tarball if "fileName" ends with ".tar", and as a directory otherwise.
+// The layout holds a single image with the content of the volume in /volume-data, like the images saved with SaveVolume,
+// and the metadata of the volume in its labels. It can be imported with ImportOCI without a registry, and a tarball can be loaded with "docker load".
+// The image is annotated with the reference name "tag" ("latest" by default) and, if given, with the full image reference "image".
+// The containers using the volume are stopped while the volume is read. The response is the JSON descriptor of the manifest of the image.
+func (h *Handler) ExportOCI(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	volumeName := ctx.Param("volume")
+	path := ctx.QueryParam("path")
+	fileName := ctx.QueryParam("fileName")
+	tag := ctx.QueryParam("tag")
+	image := ctx.QueryParam("image")
+
+	if volumeName == "" {
+		return ctx.String(http.StatusBadRequest, "volume is required")
+	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if path == "" {
+		return ctx.String(http.StatusBadRequest, "path is required")
+	}
+	if err := backend.ValidateHostPath(path); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if fileName == "" {
+		return ctx.String(http.StatusBadRequest, "fileName is required")
+	}
+	if err := backend.ValidateFileName(fileName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+
+	log.Infof("volumeName: %s", volumeName)
+	log.Infof("path: %s", path)
+	log.Infof("fileName: %s", fileName)
+	log.Infof("tag: %s", tag)
+	log.Infof("image: %s", image)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	metadata, err := backend.GetVolumeMetadata(ctxReq, cli, volumeName)
+	if client.IsErrNotFound(err) {
+		return ctx.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		h.ProgressCache.Lock()
+		delete(h.ProgressCache.m, volumeName)
+		h.ProgressCache.Unlock()
+		_ = backend.TriggerUIRefresh(ctxReq, cli)
+	}()
+
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("export", volumeSize(ctxReq, cli, volumeName))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
+	h.ProgressCache.Lock()
+	h.ProgressCache.m[volumeName] = progress
+	h.ProgressCache.Unlock()
+
+	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The digest of the layer is part of the manifest, so the layer is built into a temporary file before the layout is written
+	layerFile, err := os.CreateTemp("", "vackup-oci-layer-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = layerFile.Close()
+		_ = os.Remove(layerFile.Name())
+	}()
+
+	// Stop container(s)
+	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+
+	layer, buildErr := buildOCILayer(ctxReq, cli, volumeName, fileName, layerFile)
+
+	// Start container(s) as soon as the volume was read
+	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	if buildErr != nil {
+		return buildErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := layerFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	script := `mkdir -p "$VACKUP_LAYOUT" && tar -xf - -C "$VACKUP_LAYOUT"`
+	if strings.HasSuffix(fileName, ociTarballExt) {
+		script = `cat > "$VACKUP_LAYOUT"`
+	}
+	log.Infof("script: %s", script)
+
+	var manifest ocispec.Descriptor
+	pr, pw := io.Pipe()
+	g, gCtx := errgroup.WithContext(ctxReq)
+	g.Go(func() error {
+		desc, err := backend.WriteOCILayout(pw, layer, layerFile, backend.OCIImageOptions{
+			RefName:   tag,
+			ImageName: image,
+			Metadata:  metadata,
+			Created:   time.Now(),
+		})
+		manifest = desc
+
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		err := backend.StreamIntoContainer(gCtx, cli, &container.Config{
+			Image: internal.AlpineTarZstdImage,
+			Cmd:   backend.ShellCmd(script),
+			Env: []string{
+				"VACKUP_LAYOUT=" + "/vackup" + "/" + filepath.Base(fileName),
+			},
+			User: "root",
+			Labels: map[string]string{
+				"com.docker.desktop.extension":          "true",
+				"com.docker.desktop.extension.name":     "Volumes Backup & Share",
+				"com.docker.compose.project":            "docker_volumes-backup-extension-desktop-extension",
+				"com.volumes-backup-extension.action":   "export",
+				"com.volumes-backup-extension.volume":   volumeName,
+				"com.volumes-backup-extension.path":     path,
+				"com.volumes-backup-extension.fileName": fileName,
+			},
+		}, &container.HostConfig{
+			Binds: []string{
+				path + ":" + "/vackup",
+			},
+		}, pr)
+
+		// Unblock the writing of the layout if it can't be written to the host
+		_ = pr.CloseWithError(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	h.trackBackup(backend.NewBackup(backend.BackupOCILayout, volumeName, filepath.Join(path, fileName), time.Now()))
+	return ctx.JSON(http.StatusCreated, manifest)
+}
+
+// buildOCILayer reads the content of the volume with a helper container and writes the compressed layer of the image into w.
+func buildOCILayer(ctxReq context.Context, cli *client.Client, volumeName, fileName string, w io.Writer) (backend.OCILayer, error) {
+	var layer backend.OCILayer
+	pr, pw := io.Pipe()
+
+	g, gCtx := errgroup.WithContext(ctxReq)
+	g.Go(func() error {
+		config, hostConfig := tarToStdoutConfig(volumeName, fileName, "", backend.Filter{})
+		err := backend.StreamFromContainer(gCtx, cli, config, hostConfig, pw)
+
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		layer, err = backend.BuildOCILayer(pr, w)
+
+		// Stop reading the volume if the layer can't be built
+		_ = pr.CloseWithError(err)
+		return err
+	})
+
+	return layer, g.Wait()
+}
+
+// ImportOCI imports into the volume the content of an image of the OCI image layout located in the host at "path",
+// a directory or, if "path" ends with ".tar", a tarball, e.g. written by ExportOCI or "docker save".
+// The image is the one annotated with the reference name "tag", which is required only if the layout holds several images.
+// The content of the volume is read from the /volume-data directory of the image, and the other files of the image are ignored.
+// The layers of the image are applied in order, and the files deleted by the whiteouts of a layer are removed from the lower layers,
+// so an image saved on top of a base image, e.g. loaded from "docker save", can be imported.
+// The blobs are checked against their digest, and the image is extracted into a staging volume before the volume is modified,
+// so a corrupt layout leaves the volume untouched and the response is 422 Unprocessable Entity.
+// "strategy" and "safetySnapshot" are the same as for ImportTarGzFile.
+// If the volume does not exist, it is created with the metadata stored in the labels of the image, if any.
+func (h *Handler) ImportOCI(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	volumeName := ctx.Param("volume")
+	path := ctx.QueryParam("path")
+	tag := ctx.QueryParam("tag")
+	strategyName := ctx.QueryParam("strategy")
+	safetySnapshot := ctx.QueryParam("safetySnapshot") == "true"
+
+	if volumeName == "" {
+		return ctx.String(http.StatusBadRequest, "volume is required")
+	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	if path == "" {
+		return ctx.String(http.StatusBadRequest, "path is required")
+	}
+	if err := backend.ValidateHostPath(path); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	strategy, err := backend.ParseImportStrategy(strategyName)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	log.Infof("volumeName: %s", volumeName)
+	log.Infof("path: %s", path)
+	log.Infof("tag: %s", tag)
+	log.Infof("strategy: %s", strategy)
+	log.Infof("safetySnapshot: %t", safetySnapshot)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		h.ProgressCache.Lock()
+		delete(h.ProgressCache.m, volumeName)
+		h.ProgressCache.Unlock()
+		_ = backend.TriggerUIRefresh(ctxReq, cli)
+	}()
+
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("import", 0)
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
+	h.ProgressCache.Lock()
+	h.ProgressCache.m[volumeName] = progress
+	h.ProgressCache.Unlock()
+
+	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	tarball := strings.HasSuffix(path, ociTarballExt)
+
+	// The index, the manifest and the configuration are small, and read entirely before the layers
+	var index bytes.Buffer
+	if err := readOCIFile(ctxReq, cli, volumeName, path, tarball, backend.OCIIndexFile, &index); err != nil {
+		if strings.Contains(err.Error(), "bind source path does not exist") {
+			return ctx.String(http.StatusNotFound, err.Error())
+		}
+		var exitErr *backend.ExitError
+		if errors.As(err, &exitErr) {
+			return ctx.String(http.StatusUnprocessableEntity, "path is not an OCI image layout: "+backend.OCIIndexFile+" could not be read")
+		}
+		return err
+	}
+	manifestDesc, err := backend.ParseOCIIndex(index.Bytes(), tag)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+	manifestData, err := readOCIBlob(ctxReq, cli, volumeName, path, tarball, manifestDesc.Digest)
+	if err != nil {
+		return err
+	}
+	manifest, err := backend.ParseOCIManifest(manifestData, manifestDesc)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+	configData, err := readOCIBlob(ctxReq, cli, volumeName, path, tarball, manifest.Config.Digest)
+	if err != nil {
+		return err
+	}
+	metadata, ok, err := backend.ParseOCIConfig(configData, manifest.Config)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// A volume that does not exist yet is created as it was when the image was exported
+	if ok {
+		if _, err := backend.CreateVolumeIfNotExists(ctxReq, cli, volumeName, metadata); err != nil {
+			return err
+		}
+	}
+
+	staging, err := backend.CreateStagingVolume(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+	log.Infof("staging: %s", staging)
+	defer func() {
+		if err := cli.VolumeRemove(context.Background(), staging, true); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	// The files a layer deletes are only known once it was read entirely, and they must be removed from the staging volume
+	// before the files of the layer are extracted, so every layer is extracted into a temporary file first
+	layerFile, err := os.CreateTemp("", "vackup-oci-layer-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = layerFile.Close()
+		_ = os.Remove(layerFile.Name())
+	}()
+
+	// The layers are applied in order on top of each other
+	for _, layer := range manifest.Layers {
+		blobPath, err := backend.OCIBlobPath(layer.Digest)
+		if err != nil {
+			return ctx.String(http.StatusUnprocessableEntity, err.Error())
+		}
+		log.Infof("layer: %s", blobPath)
+
+		if err := layerFile.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := layerFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		blobReader, blobWriter := io.Pipe()
+		var whiteouts []backend.OCIWhiteout
+		var layerErr error
+
+		g, gCtx := errgroup.WithContext(ctxReq)
+		g.Go(func() error {
+			err := readOCIFile(gCtx, cli, volumeName, path, tarball, blobPath, blobWriter)
+
+			// A nil error closes the pipe with io.EOF
+			_ = blobWriter.CloseWithError(err)
+			return err
+		})
+		g.Go(func() error {
+			whiteouts, layerErr = backend.ExtractOCILayer(blobReader, layer, layerFile)
+
+			// Stop reading the blob if the layer is corrupt
+			_ = blobReader.CloseWithError(layerErr)
+			return layerErr
+		})
+
+		err = g.Wait()
+		if layerErr != nil {
+			return ctx.String(http.StatusUnprocessableEntity, "image could not be extracted: "+layerErr.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := layerFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err = removeWhiteouts(ctxReq, cli, staging, whiteouts)
+		if err == nil {
+			// The staging volume only holds the files of the lower layers, so there is nothing to remove or to skip
+			err = extractIntoVolume(ctxReq, cli, staging, layerFile, "", nil, backend.ImportStrategyMerge)
+		}
+		var exitErr *backend.ExitError
+		if errors.As(err, &exitErr) {
+			return ctx.String(http.StatusUnprocessableEntity, "image could not be extracted: "+strings.TrimSpace(exitErr.Error()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.restoreVolume(ctx, cli, volumeName, safetySnapshot, http.StatusOK, nil, func() error {
+		// The progress reports the extraction of the image, not the copy of the staging volume
+		return backend.CopyVolume(backend.WithProgress(ctxReq, nil), cli, staging, volumeName, strategy)
+	})
+}
+
+// removeWhiteouts removes from the volume the files of the lower layers of an image deleted by the whiteouts of a layer.
+// The alpine-tar-zstd image must be present.
+func removeWhiteouts(ctx context.Context, cli *client.Client, volumeName string, whiteouts []backend.OCIWhiteout) error {
+	if len(whiteouts) == 0 {
+		return nil
+	}
+
+	// The paths are passed to the script as positional parameters, with a trailing slash if only the content of the directory is deleted
+	var args []string
+	for _, w := range whiteouts {
+		p := w.Path
+		if w.Opaque {
+			p = strings.TrimSuffix(p, "/") + "/"
+		}
+		args = append(args, p)
+	}
+	script := `for p; do case "$p" in ` +
+		`*/) if [ -d "/vackup-volume/$p" ]; then find "/vackup-volume/$p" -mindepth 1 -maxdepth 1 -exec rm -rf -- {} + || exit 1; fi ;; ` +
+		`*) rm -rf -- "/vackup-volume/$p" || exit 1 ;; ` +
+		`esac; done`
+	log.Infof("script: %s", script)
+
+	return backend.StreamFromContainer(ctx, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(script, args...),
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "import",
+			"com.volumes-backup-extension.volume": volumeName,
+		},
+	}, &container.HostConfig{
+		Binds: []string{
+			volumeName + ":" + "/vackup-volume",
+		},
+	}, os.Stdout)
+}
+
+// readOCIBlob returns the content of the blob with the digest from the OCI image layout located in the host at "path".
+func readOCIBlob(ctx context.Context, cli *client.Client, volumeName, path string, tarball bool, dgst digest.Digest) ([]byte, error) {
+	blobPath, err := backend.OCIBlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := readOCIFile(ctx, cli, volumeName, path, tarball, blobPath, &out); err != nil {
+		return nil, fmt.Errorf("%s could not be read: %w", blobPath, err)
+	}
+	return out.Bytes(), nil
+}
+
+// readOCIFile writes into w the content of the file "name" of the OCI image layout located in the host at "path", a directory or a tarball.
+// The alpine-tar-zstd image must be present.
+func readOCIFile(ctx context.Context, cli *client.Client, volumeName, path string, tarball bool, name string, w io.Writer) error {
+	// The name is passed to the script as a positional parameter
+	script := `cat -- "/vackup/$1"`
+	if tarball {
+		// The members of a tarball written by "tar -cf - ." start with "./"
+		script = `tar -xOf /vackup -- "$1" 2>/dev/null || tar -xOf /vackup -- "./$1"`
+	}
+
+	return backend.StreamFromContainer(ctx, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(script, name),
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "import",
+			"com.volumes-backup-extension.volume": volumeName,
+			"com.volumes-backup-extension.path":   path,
+		},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup", ReadOnly: true},
+		},
+	}, w)
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestExportAndImportOCI(t *testing.T) {
+	for _, fileName := range []string{"db-layout", "db-layout.tar"} {
+		t.Run(fileName, func(t *testing.T) {
+			volumeID := "5e7a9c1b3d5f7b9d1f3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d"
+			imported := "6f8b0d2c4e6a8c0e2a4c6e8b0d2f4a6c8e0b2d4f6a8c0e2b4d6f8a0c2e4b6d8f"
+			cli := setupDockerClient(t)
+			_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+				Driver: "local",
+				Name:   volumeID,
+				Labels: map[string]string{"app": "shop"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = cli.VolumeRemove(context.Background(), volumeID, true)
+				_ = cli.VolumeRemove(context.Background(), imported, true)
+			}()
+			runInVolume(t, cli, volumeID, "mkdir -p /data/etc && echo db > /data/etc/content.txt && ln /data/etc/content.txt /data/content.txt")
+
+			tmpDir, err := os.MkdirTemp("", "oci")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(tmpDir)
+			}()
+
+			// Export
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", tmpDir)
+			q.Set("fileName", fileName)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export-oci")
+			c.SetParamNames("volume")
+			c.SetParamValues(volumeID)
+			h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+			err = h.ExportOCI(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, rec.Code)
+			var manifest ocispec.Descriptor
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &manifest))
+			require.Equal(t, "latest", manifest.Annotations[ocispec.AnnotationRefName])
+
+			// Import into a new volume
+			q = make(url.Values)
+			q.Set("path", filepath.Join(tmpDir, fileName))
+			req = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec = httptest.NewRecorder()
+			c = e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import-oci")
+			c.SetParamNames("volume")
+			c.SetParamValues(imported)
+
+			err = h.ImportOCI(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			runInVolume(t, cli, imported, "grep -q db /data/etc/content.txt && grep -q db /data/content.txt")
+			v, err := cli.VolumeInspect(context.Background(), imported)
+			require.NoError(t, err)
+			require.Equal(t, "shop", v.Labels["app"])
+		})
+	}
+}
+
+func TestExportOCIWithHostileInputShouldFail(t *testing.T) {
+	tests := map[string]struct {
+		volume   string
+		path     string
+		fileName string
+	}{
+		"volume":    {volume: "$(reboot)", path: "/tmp", fileName: "layout"},
+		"path":      {volume: "db", path: "/tmp/../etc", fileName: "layout"},
+		"file name": {volume: "db", path: "/tmp", fileName: "../layout"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", tt.path)
+			q.Set("fileName", tt.fileName)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/export-oci")
+			c.SetParamNames("volume")
+			c.SetParamValues(tt.volume)
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Export
+			err := h.ExportOCI(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestImportOCIWithInvalidInputShouldFail(t *testing.T) {
+	tests := map[string]struct {
+		path     string
+		strategy string
+	}{
+		"relative path":    {path: "layout.tar"},
+		"path":             {path: "/tmp/../etc/layout.tar"},
+		"unknown strategy": {path: "/tmp/layout.tar", strategy: "overwrite"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			q := make(url.Values)
+			q.Set("path", tt.path)
+			q.Set("strategy", tt.strategy)
+			req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import-oci")
+			c.SetParamNames("volume")
+			c.SetParamValues("db")
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Import
+			err := h.ImportOCI(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
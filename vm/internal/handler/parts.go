@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// partExt is the extension of the parts of a multi-part archive, followed by the number of the part on 3 digits starting at 001,
+	// e.g. "my-volume.tar.zst.part001".
+	partExt = ".part"
+	// partIndexExt is the extension of the index of a multi-part archive, which lists the SHA-256 checksum of every part in the format of sha256sum.
+	partIndexExt = ".index"
+)
+
+var partRegexp = regexp.MustCompile(`\.part[0-9]{3}$`)
+
+// partsGlob returns the shell glob that matches the parts of the archive, sorted by number when expanded.
+// archive is the shell word that expands to the path of the archive, e.g. "$VACKUP_ARCHIVE" in double quotes.
+func partsGlob(archive string) string {
+	return archive + partExt + "[0-9][0-9][0-9]"
+}
+
+// splitHostPath splits a path of the host into its directory, its separator and its base name.
+// The host can be Windows, where paths use backslashes.
+func splitHostPath(p string) (dir, sep, base string) {
+	i := strings.LastIndexAny(p, `/\`)
+	if i == -1 {
+		return "", "", p
+	}
+	return p[:i], p[i : i+1], p[i+1:]
+}
+
+// multiPartArchive reports whether the host path is the index or a part of a multi-part archive.
+// If so, it returns the directory and the separator of the path, and the name of the archive without the extension of the index or the part.
+func multiPartArchive(path string) (dir, sep, fileName string, ok bool) {
+	dir, sep, base := splitHostPath(path)
+	switch {
+	case strings.HasSuffix(base, partIndexExt):
+		return dir, sep, strings.TrimSuffix(base, partIndexExt), true
+	case partRegexp.MatchString(base):
+		return dir, sep, partRegexp.ReplaceAllString(base, ""), true
+	default:
+		return "", "", "", false
+	}
+}
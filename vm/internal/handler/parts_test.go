@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestMultiPartArchive(t *testing.T) {
+	tests := []struct {
+		path     string
+		dir      string
+		sep      string
+		fileName string
+		ok       bool
+	}{
+		{path: "/tmp/backup.tar.zst.index", dir: "/tmp", sep: "/", fileName: "backup.tar.zst", ok: true},
+		{path: "/tmp/backup.tar.zst.part001", dir: "/tmp", sep: "/", fileName: "backup.tar.zst", ok: true},
+		{path: `C:\Users\me\backup.tar.gz.part012`, dir: `C:\Users\me`, sep: `\`, fileName: "backup.tar.gz", ok: true},
+		{path: "/tmp/backup.tar.zst.part1", ok: false},
+		{path: "/tmp/backup.tar.zst", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			dir, sep, fileName, ok := multiPartArchive(tt.path)
+
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.dir, dir)
+			require.Equal(t, tt.sep, sep)
+			require.Equal(t, tt.fileName, fileName)
+		})
+	}
+}
+
+func TestExportVolumeWithMaxPartSizeAndStreamShouldFail(t *testing.T) {
+	// Setup
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("stream", "true")
+	q.Set("maxPartSize", "1M")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/export")
+	c.SetParamNames("volume")
+	c.SetParamValues("volume")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+	}
+
+	// Export volume
+	err := h.ExportVolume(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestExportAndImportMultiPartArchive(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volumeID := "5d7f9b1c3e5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a5c7e"
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	// Random data does not compress, so the archive is larger than a part
+	runInVolume(t, cli, volumeID, "head -c 300000 /dev/urandom > /data/random.bin && echo hello > /data/hello.txt")
+
+	tmpDir, err := os.MkdirTemp("", "parts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	fileName := volumeID + ".tar.gz"
+
+	// Export volume
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", tmpDir)
+	q.Set("fileName", fileName)
+	q.Set("maxPartSize", "100k")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/export")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	err = h.ExportVolume(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	parts, err := filepath.Glob(filepath.Join(tmpDir, fileName+partExt+"[0-9][0-9][0-9]"))
+	require.NoError(t, err)
+	require.Len(t, parts, 3)
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		require.NoError(t, err)
+		require.LessOrEqual(t, info.Size(), int64(100*1024))
+	}
+	require.FileExists(t, filepath.Join(tmpDir, fileName+partIndexExt))
+	require.FileExists(t, filepath.Join(tmpDir, fileName+backend.ManifestExt))
+
+	// Empty volume
+	runInVolume(t, cli, volumeID, "rm -rf /data/*")
+
+	// Import volume from the index
+	q = make(url.Values)
+	q.Set("path", filepath.Join(tmpDir, fileName+partIndexExt))
+	req = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	runInVolume(t, cli, volumeID, "test $(wc -c < /data/random.bin) -eq 300000 && grep -q hello /data/hello.txt")
+
+	// A corrupted part fails the import and leaves the volume untouched
+	part, err := os.ReadFile(parts[1])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(parts[1], []byte("corrupted"), 0644))
+	q = make(url.Values)
+	q.Set("path", parts[0])
+	req = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	runInVolume(t, cli, volumeID, "grep -q hello /data/hello.txt")
+
+	// A part that is not listed in the index fails the import
+	require.NoError(t, os.WriteFile(parts[1], part, 0644))
+	extraPart := filepath.Join(tmpDir, fileName+partExt+"004")
+	require.NoError(t, os.WriteFile(extraPart, []byte("extra"), 0644))
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// A missing index fails the import instead of skipping the verification
+	require.NoError(t, os.Remove(extraPart))
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, fileName+partIndexExt)))
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+
+	err = h.ImportTarGzFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	runInVolume(t, cli, volumeID, "grep -q hello /data/hello.txt")
+}
@@ -1,18 +1,133 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
+// progressStreamInterval is the interval between two events of the progress stream.
+var progressStreamInterval = time.Second
+
 type ProgressCache struct {
 	sync.RWMutex
-	m map[string]string // map of volumes and actions, e.g. m["vol-1"] = "export"
+	m map[string]*backend.Progress // map of volumes and the progress of their action, e.g. m["vol-1"] = backend.NewProgress("export", 1000)
+}
+
+// statuses returns the status of the action running for every volume.
+func (c *ProgressCache) statuses() map[string]backend.ProgressStatus {
+	c.RLock()
+	defer c.RUnlock()
+
+	statuses := make(map[string]backend.ProgressStatus, len(c.m))
+	for volumeName, progress := range c.m {
+		statuses[volumeName] = progress.Status()
+	}
+	return statuses
 }
 
-// ActionsInProgress retrieves the current action (i.e. export, import, save or load) that is running for every volume.
+// ActionsInProgress retrieves the current action (i.e. export, import, clone, save or load) that is running for every volume,
+// with the number of bytes and files processed so far, the total number of bytes to process and the estimated number of seconds left.
+// The progress of a push or a pull also lists the bytes transferred for every layer of the image.
 func (h *Handler) ActionsInProgress(ctx echo.Context) error {
-	return ctx.JSON(http.StatusOK, h.ProgressCache.m)
+	return ctx.JSON(http.StatusOK, h.ProgressCache.statuses())
+}
+
+// StreamProgress streams the actions in progress as server-sent events, one event per second with the same content as ActionsInProgress,
+// until the client disconnects.
+func (h *Handler) StreamProgress(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+
+	ctx.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	ctx.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	ctx.Response().WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(progressStreamInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(h.ProgressCache.statuses())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(ctx.Response(), "data: %s\n\n", data); err != nil {
+			return err
+		}
+		ctx.Response().Flush()
+
+		select {
+		case <-ctxReq.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// volumeSize returns the size of the content of the volume in bytes, or 0 if it cannot be computed.
+func volumeSize(ctx context.Context, cli *client.Client, volumeName string) int64 {
+	sizes, err := backend.GetVolumesSize(ctx, cli, volumeName)
+	if err != nil {
+		log.Warnf("size of volume %s could not be computed: %s", volumeName, err)
+		return 0
+	}
+	return sizes[volumeName].Bytes
+}
+
+// archiveSize returns the size of the files of the archive located in the host at "archive" from the manifest written next to it,
+// or 0 if the archive has no manifest.
+func archiveSize(ctx context.Context, cli *client.Client, archive string) int64 {
+	data, err := readHostFile(ctx, cli, archive+backend.ManifestExt)
+	if err != nil {
+		if !strings.Contains(err.Error(), "bind source path does not exist") {
+			log.Warnf("manifest of %s could not be read: %s", archive, err)
+		}
+		return 0
+	}
+
+	entries, err := backend.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		log.Warnf("ignoring the manifest of %s: %s", archive, err)
+		return 0
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return size
+}
+
+// imageDataSize returns the size of the content of the volume saved in the image, or 0 if it cannot be computed.
+// An image saved on top of a base image only holds the changes of the volume in its last layer, so the size of the whole content
+// is read from the provenance of the image. The images saved by earlier versions of the extension hold the whole content in their last layer.
+func imageDataSize(ctx context.Context, cli *client.Client, image string) int64 {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		log.Warnf("image %s could not be inspected: %v", image, err)
+		return 0
+	}
+	if inspect.Config != nil {
+		if provenance, ok, err := backend.ParseProvenance(inspect.Config.Labels); ok && err == nil {
+			return provenance.Size
+		}
+	}
+
+	history, err := cli.ImageHistory(ctx, image)
+	if err != nil || len(history) == 0 {
+		log.Warnf("history of image %s could not be read: %v", image, err)
+		return 0
+	}
+	// The history starts with the most recent layer
+	return history[0].Size
 }
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestActionsInProgress(t *testing.T) {
+	// Setup
+	progress := backend.NewProgress("export", 1000)
+	_, err := io.WriteString(progress.Writer(), "-rw-r--r-- root/root 250 2022-06-01 10:00 ./index.html\n")
+	require.NoError(t, err)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/progress")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: map[string]*backend.Progress{"vol-1": progress}},
+	}
+
+	// Get the actions in progress
+	err = h.ActionsInProgress(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var m map[string]backend.ProgressStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m))
+	require.Equal(t, "export", m["vol-1"].Action)
+	require.Equal(t, int64(250), m["vol-1"].Bytes)
+	require.Equal(t, int64(1000), m["vol-1"].TotalBytes)
+	require.Equal(t, int64(1), m["vol-1"].Files)
+}
+
+func TestStreamProgress(t *testing.T) {
+	// Setup
+	interval := progressStreamInterval
+	progressStreamInterval = 10 * time.Millisecond
+	defer func() {
+		progressStreamInterval = interval
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/progress/stream")
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+		ProgressCache: &ProgressCache{m: map[string]*backend.Progress{"vol-1": backend.NewProgress("clone", 0)}},
+	}
+
+	// Stream the actions in progress until the client disconnects
+	err := h.StreamProgress(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+	events := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n")
+	require.Greater(t, len(events), 1)
+	for _, event := range events {
+		require.True(t, strings.HasPrefix(event, "data: "), event)
+		var m map[string]backend.ProgressStatus
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &m))
+		require.Equal(t, "clone", m["vol-1"].Action)
+		require.Equal(t, int64(-1), m["vol-1"].ETA)
+	}
+}
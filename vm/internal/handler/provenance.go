@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// LoadResponse is the response of LoadImage and PullVolume.
+type LoadResponse struct {
+	// Provenance is where the content of the image comes from. It is nil if the image was saved by a version of the extension that did not store it.
+	Provenance *backend.Provenance `json:"provenance,omitempty"`
+	// Warnings are the differences between the provenance of the image and the volume it was loaded into.
+	Warnings []string `json:"warnings"`
+}
+
+// imageProvenance returns the provenance of the image and the warnings about loading it into the volume.
+// It must be called before the volume is created from the metadata of the image, to compare the image with the volume as it was.
+func imageProvenance(ctx context.Context, cli *client.Client, volumeName, image string) (LoadResponse, error) {
+	response := LoadResponse{Warnings: []string{}}
+
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return response, err
+	}
+	if inspect.Config == nil {
+		return response, nil
+	}
+
+	provenance, ok, err := backend.ParseProvenance(inspect.Config.Labels)
+	if err != nil {
+		log.Warnf("ignoring the provenance of %s: %s", image, err)
+		return response, nil
+	}
+	if !ok {
+		return response, nil
+	}
+
+	var target *backend.VolumeMetadata
+	metadata, err := backend.GetVolumeMetadata(ctx, cli, volumeName)
+	if err == nil {
+		target = &metadata
+	} else if !client.IsErrNotFound(err) {
+		return response, err
+	}
+
+	response.Provenance = &provenance
+	response.Warnings = provenance.Warnings(volumeName, target, inspect.RootFS.Layers)
+	for _, warning := range response.Warnings {
+		log.Warnf("loading image %s into volume %s: %s", image, volumeName, warning)
+	}
+
+	return response, nil
+}
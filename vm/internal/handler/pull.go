@@ -1,7 +1,7 @@
 package handler
 
 import (
-	"io/ioutil"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -17,10 +17,18 @@ import (
 type PullRequest struct {
 	Reference         string `json:"reference"`
 	Base64EncodedAuth string `json:"base64EncodedAuth"`
+	// Strategy is the import strategy used to load the image into the volume, "replace" by default.
+	Strategy string `json:"strategy"`
+	// SafetySnapshot tells whether the volume is restored from a snapshot taken before the load if the load fails.
+	SafetySnapshot bool `json:"safetySnapshot"`
 }
 
 // PullVolume pulls a volume from a registry.
 // The user must be previously authenticated to the registry with `docker login <registry>`, otherwise it returns 401 StatusUnauthorized.
+// If the volume does not exist, it is created with the driver, the driver options that are safe to apply (see backend.SafeDriverOpts) and the labels of the volume the image was pushed from.
+// The bytes of every layer pulled are reported to the progress of the volume. The pull is aborted at the first error of the registry,
+// and the status code of the response tells the kind of error, see backend.RegistryError.
+// The response is a JSON LoadResponse with the provenance of the image, see LoadImage.
 func (h *Handler) PullVolume(ctx echo.Context) error {
 	var request PullRequest
 	if err := ctx.Bind(&request); err != nil {
@@ -29,6 +37,9 @@ func (h *Handler) PullVolume(ctx echo.Context) error {
 
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("reference: %s", request.Reference)
 	logrus.Infof("received pull request for volume %s\n", volumeName)
@@ -44,8 +55,9 @@ func (h *Handler) PullVolume(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	progress := backend.NewProgress("pull", 0)
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "pull"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	err = backend.TriggerUIRefresh(ctxReq, cli)
@@ -70,6 +82,12 @@ func (h *Handler) PullVolume(ctx echo.Context) error {
 	}
 	log.Infof("parsedRef.String(): %s", parsedRef.String())
 
+	strategy, err := backend.ParseImportStrategy(request.Strategy)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	log.Infof("strategy: %s", strategy)
+
 	// Pull the volume (image) from registry
 	log.Infof("Pulling image %s...", parsedRef.String())
 	pullResp, err := cli.ImagePull(ctxReq, parsedRef.String(), dockertypes.ImagePullOptions{
@@ -85,32 +103,29 @@ func (h *Handler) PullVolume(ctx echo.Context) error {
 	}
 	defer pullResp.Close()
 
-	pullRespBytes, err := ioutil.ReadAll(pullResp)
-	if err != nil {
-		return err
-	}
-
-	for _, line := range strings.Split(string(pullRespBytes), "\n") {
-		log.Info(line)
+	// The errors of the pull are part of the response, e.g. when the image does not exist
+	err = backend.ReadRegistryStream(pullResp, progress)
+	var registryErr *backend.RegistryError
+	if errors.As(err, &registryErr) {
+		return ctx.String(registryErr.StatusCode, registryErr.Error())
 	}
-
-	// Stop container(s)
-	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
 	if err != nil {
 		return err
 	}
 
-	// Load the image into the volume
-	log.Infof("Loading image %s into volume %s...", parsedRef.String(), volumeName)
-	if err := backend.Load(ctxReq, cli, volumeName, parsedRef.String()); err != nil {
+	response, err := imageProvenance(ctxReq, cli, volumeName, parsedRef.String())
+	if err != nil {
 		return err
 	}
 
-	// Start container(s)
-	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
-	if err != nil {
+	// A volume that does not exist yet is created as it was when the image was pushed
+	if err := createVolumeFromImageMetadata(ctxReq, cli, volumeName, parsedRef.String()); err != nil {
 		return err
 	}
 
-	return ctx.String(http.StatusCreated, "")
+	return h.restoreVolume(ctx, cli, volumeName, request.SafetySnapshot, http.StatusCreated, response, func() error {
+		// Load the image into the volume
+		log.Infof("Loading image %s into volume %s...", parsedRef.String(), volumeName)
+		return backend.Load(ctxReq, cli, volumeName, parsedRef.String(), strategy)
+	})
 }
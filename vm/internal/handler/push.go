@@ -1,13 +1,14 @@
 package handler
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/docker/distribution/reference"
 	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 
 	"github.com/docker/volumes-backup-extension/internal/backend"
@@ -17,18 +18,18 @@ import (
 type PushRequest struct {
 	Reference         string `json:"reference"`
 	Base64EncodedAuth string `json:"base64EncodedAuth"`
-}
-
-type PushErrorLine struct {
-	ErrorDetail ErrorDetail `json:"errorDetail"`
-	Error       string      `json:"error"`
-}
-type ErrorDetail struct {
-	Message string `json:"message"`
+	// Base is the reference of an image pushed before from the volume. If set, the pushed image is saved on top of it and only
+	// adds a layer with the changes of the volume, which is the only layer uploaded if the registry has the base image.
+	// The base image is pulled if it is not present.
+	Base string `json:"base"`
 }
 
 // PushVolume pushes a volume to a registry.
+// The image carries the metadata of the volume in its labels, see backend.Save.
 // The user must be previously authenticated to the registry with `docker login <registry>`, otherwise it returns 401 StatusUnauthorized.
+// The bytes of every layer pushed are reported to the progress of the volume. The push is aborted at the first error of the registry,
+// and the status code of the response tells the kind of error, see backend.RegistryError.
+// The containers using the volume are started again once it is saved, before the push, so an aborted push leaves them running.
 func (h *Handler) PushVolume(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 
@@ -38,6 +39,9 @@ func (h *Handler) PushVolume(ctx echo.Context) error {
 	}
 
 	volumeName := ctx.Param("volume")
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("reference: %s", request.Reference)
 	log.Infof("received push request for volume %s\n", volumeName)
@@ -53,8 +57,9 @@ func (h *Handler) PushVolume(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	progress := backend.NewProgress("push", 0)
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "push"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	err = backend.TriggerUIRefresh(ctxReq, cli)
@@ -79,6 +84,28 @@ func (h *Handler) PushVolume(ctx echo.Context) error {
 	}
 	log.Infof("parsedRef.String(): %s", parsedRef.String())
 
+	var base string
+	if request.Base != "" {
+		parsedBase, err := reference.ParseAnyReference(request.Base)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		base = parsedBase.String()
+		log.Infof("base: %s", base)
+
+		err = pullIfNotExists(ctxReq, cli, base, request.Base64EncodedAuth)
+		var registryErr *backend.RegistryError
+		if errors.As(err, &registryErr) {
+			return ctx.String(registryErr.StatusCode, registryErr.Error())
+		}
+		if err != nil {
+			return err
+		}
+		if code, err := checkBaseImage(ctxReq, cli, base); err != nil {
+			return ctx.String(code, err.Error())
+		}
+	}
+
 	// Stop container(s)
 	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
 	if err != nil {
@@ -86,7 +113,14 @@ func (h *Handler) PushVolume(ctx echo.Context) error {
 	}
 
 	// Save the content of the volume into an image
-	if err := backend.Save(ctxReq, cli, volumeName, parsedRef.String()); err != nil {
+	_, saveErr := backend.Save(ctxReq, cli, volumeName, parsedRef.String(), base, backend.Filter{})
+
+	// Start container(s) as soon as the volume was read, even if the request was canceled: the push only reads the image
+	err = backend.StartContainersByName(context.Background(), cli, stoppedContainers)
+	if saveErr != nil {
+		return saveErr
+	}
+	if err != nil {
 		return err
 	}
 
@@ -99,34 +133,36 @@ func (h *Handler) PushVolume(ctx echo.Context) error {
 	}
 	defer pushResp.Close()
 
-	response, err := ioutil.ReadAll(pushResp)
-
-	for _, line := range strings.Split(string(response), "\n") {
-		log.Info(line)
+	// The errors of the push are part of the response, e.g.
+	// {"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}
+	err = backend.ReadRegistryStream(pushResp, progress)
+	var registryErr *backend.RegistryError
+	if errors.As(err, &registryErr) {
+		return ctx.String(registryErr.StatusCode, registryErr.Error())
+	}
+	if err != nil {
+		return err
+	}
 
-		if !strings.Contains(line, "error") {
-			continue
-		}
+	h.trackBackup(backend.NewBackup(backend.BackupRegistry, volumeName, parsedRef.String(), time.Now()))
+	return ctx.String(http.StatusCreated, "")
+}
 
-		pel := PushErrorLine{}
-		if err := json.Unmarshal([]byte(line), &pel); err == nil {
-			// the image push had an error, e.g:
-			// {"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}
-			// or
-			// {"errorDetail":{"message":"no basic auth credentials"},"error":"no basic auth credentials"}
-			if pel.Error == "unauthorized: authentication required" || pel.Error == "no basic auth credentials" {
-				return ctx.String(http.StatusUnauthorized, pel.Error)
-			} else {
-				return ctx.String(http.StatusInternalServerError, pel.Error)
-			}
-		}
+// pullIfNotExists pulls the image if it is not present.
+func pullIfNotExists(ctx context.Context, cli *client.Client, image, encodedAuth string) error {
+	_, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if !client.IsErrNotFound(err) {
+		return err
 	}
 
-	// Start container(s)
-	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	reader, err := cli.ImagePull(ctx, image, dockertypes.ImagePullOptions{
+		RegistryAuth: encodedAuth,
+	})
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 
-	return ctx.String(http.StatusCreated, "")
+	// The errors that occur while the image is pulled are part of the response
+	return backend.ReadRegistryStream(reader, nil)
 }
@@ -1,33 +1,61 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 
 	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/log"
 )
 
+// SaveVolume saves the content of a volume into the image "image".
+// The "include" and "exclude" glob patterns and the .backupignore file select the saved files, as they do for exports.
+// If "base" is set, the image is saved on top of that image, which must have been saved from the volume before, and only adds a layer
+// with the changes of the volume since then, see backend.Save.
+// The response is the JSON provenance of the image, which is also stored in its labels.
 func (h *Handler) SaveVolume(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
 	image := ctx.QueryParam("image")
+	base := ctx.QueryParam("base")
+	filter := backend.Filter{
+		Include: ctx.QueryParams()["include"],
+		Exclude: ctx.QueryParams()["exclude"],
+	}
 
 	if volumeName == "" {
 		return ctx.String(http.StatusBadRequest, "volume is required")
 	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 	if image == "" {
 		return ctx.String(http.StatusBadRequest, "image is required")
 	}
+	if err := filter.Validate(); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
 
 	log.Infof("volumeName: %s", volumeName)
 	log.Infof("image: %s", image)
+	log.Infof("base: %s", base)
+	log.Infof("filter: %+v", filter)
 
 	cli, err := h.DockerClient()
 	if err != nil {
 		return err
 	}
+
+	if base != "" {
+		if code, err := checkBaseImage(ctxReq, cli, base); err != nil {
+			return ctx.String(code, err.Error())
+		}
+	}
 	defer func() {
 		h.ProgressCache.Lock()
 		delete(h.ProgressCache.m, volumeName)
@@ -35,8 +63,13 @@ func (h *Handler) SaveVolume(ctx echo.Context) error {
 		_ = backend.TriggerUIRefresh(ctxReq, cli)
 	}()
 
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("save", volumeSize(ctxReq, cli, volumeName))
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
 	h.ProgressCache.Lock()
-	h.ProgressCache.m[volumeName] = "save"
+	h.ProgressCache.m[volumeName] = progress
 	h.ProgressCache.Unlock()
 
 	err = backend.TriggerUIRefresh(ctxReq, cli)
@@ -51,7 +84,11 @@ func (h *Handler) SaveVolume(ctx echo.Context) error {
 	}
 
 	// Save volume into an image
-	if err := backend.Save(ctxReq, cli, volumeName, image); err != nil {
+	provenance, err := backend.Save(ctxReq, cli, volumeName, image, base, filter)
+	if errors.Is(err, backend.ErrNoDataIndex) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
@@ -61,5 +98,20 @@ func (h *Handler) SaveVolume(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	return ctx.String(http.StatusCreated, "")
+	return ctx.JSON(http.StatusCreated, provenance)
+}
+
+// checkBaseImage returns the status code and the error to respond with if the image can't be the base of an incremental save.
+func checkBaseImage(ctx context.Context, cli *client.Client, base string) (int, error) {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, base)
+	if client.IsErrNotFound(err) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if inspect.Config == nil || inspect.Config.Labels[backend.DataImageLabel] != "true" {
+		return http.StatusBadRequest, fmt.Errorf("image %s was not saved by this version of the extension and can't be the base of another image", base)
+	}
+	return 0, nil
 }
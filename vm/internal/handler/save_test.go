@@ -8,7 +8,6 @@ import (
 	"net/url"
 	"os"
 	"runtime"
-	"strconv"
 	"testing"
 
 	"github.com/docker/docker/api/types"
@@ -18,6 +17,8 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
 )
 
 func TestSaveVolume(t *testing.T) {
@@ -105,5 +106,90 @@ func TestSaveVolume(t *testing.T) {
 	require.Len(t, summary, 1)
 	require.Equal(t, imageID, summary[0].RepoTags[0])
 	t.Logf("Image size after saving volume into it: %d", summary[0].Size)
-	require.Regexp(t, `\d{7}`, strconv.FormatInt(summary[0].Size, 10), "the image size should be between 1 and 10 MB")
+	require.Less(t, summary[0].Size, int64(100*1024), "the image should only hold the content of the volume")
+	require.Equal(t, "true", summary[0].Labels[backend.DataImageLabel])
+}
+
+func TestSaveVolumeOnTopOfBaseImage(t *testing.T) {
+	volumeID := "3c5e7a9c1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a5c7e9b1d3f5a"
+	loaded := "4d6f8b0d2c4e6a8c0e2a4c6e8b0d2f4a6c8e0b2d4f6a8c0e2b4d6f8a0c2e4b6d"
+	base := "vackup-incremental-test-img:base"
+	image := "vackup-incremental-test-img:next"
+	cli := setupDockerClient(t)
+	_, err := cli.VolumeCreate(context.Background(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+		_ = cli.VolumeRemove(context.Background(), loaded, true)
+		_, _ = cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{Force: true})
+		_, _ = cli.ImageRemove(context.Background(), base, types.ImageRemoveOptions{Force: true})
+	}()
+	runInVolume(t, cli, volumeID, "mkdir -p /data/old && echo old > /data/old/old.txt && echo kept > /data/kept.txt")
+	_, err = backend.Save(context.Background(), cli, volumeID, base, "", backend.Filter{})
+	require.NoError(t, err)
+	runInVolume(t, cli, volumeID, "rm -r /data/old && echo new > /data/new.txt")
+
+	// Save on top of the base image
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("image", image)
+	q.Set("base", base)
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/save")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	err = h.SaveVolume(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	baseInspect, _, err := cli.ImageInspectWithRaw(context.Background(), base)
+	require.NoError(t, err)
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	require.NoError(t, err)
+	require.Equal(t, append(baseInspect.RootFS.Layers, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1]), inspect.RootFS.Layers)
+	require.Equal(t, baseInspect.ID, inspect.Config.Labels[backend.BaseLabel])
+
+	// The whiteouts of the upper layer hide the deleted files
+	require.NoError(t, backend.Load(context.Background(), cli, loaded, image, backend.ImportStrategyReplace))
+	runInVolume(t, cli, loaded, "test ! -e /data/old && grep -q kept /data/kept.txt && grep -q new /data/new.txt")
+}
+
+func TestSaveVolumeWithNonDataBaseImageShouldFail(t *testing.T) {
+	cli := setupDockerClient(t)
+	reader, err := cli.ImagePull(context.Background(), "docker.io/library/busybox:1.35", types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("image", "vackup-incremental-test-img:next")
+	q.Set("base", "docker.io/library/busybox:1.35")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/save")
+	c.SetParamNames("volume")
+	c.SetParamValues("db")
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	err = h.SaveVolume(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
 }
@@ -11,6 +11,10 @@ import (
 func (h *Handler) VolumeSize(ctx echo.Context) error {
 	ctxReq := ctx.Request().Context()
 	volumeName := ctx.Param("volume")
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
 	cli, err := h.DockerClient()
 	if err != nil {
 		return err
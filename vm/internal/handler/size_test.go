@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +16,8 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
 )
 
 func TestVolumeSize(t *testing.T) {
@@ -85,3 +88,28 @@ func TestVolumeSize(t *testing.T) {
 	require.Equal(t, `{"Bytes":16000,"Human":"16.0 kB"}
 `, size)
 }
+
+func TestVolumeSizeWithHostileInputShouldFail(t *testing.T) {
+	for _, volumeName := range []string{"$(reboot)", "volume;reboot", "*", "../volume", "-rf", "volume\nreboot"} {
+		t.Run(volumeName, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/size")
+			c.SetParamNames("volume")
+			c.SetParamValues(volumeName)
+			h := &Handler{
+				DockerClient:  func() (*client.Client, error) { return nil, errors.New("docker client should not be used") },
+				ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+			}
+
+			// Volume size
+			err := h.VolumeSize(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// RestoreError is the response body of a failed restore when a safety snapshot of the volume was taken.
+type RestoreError struct {
+	Message string `json:"message"`
+	// RolledBack reports whether the volume was restored to its content before the restore.
+	RolledBack bool `json:"rolledBack"`
+	// Snapshot is the volume that holds the content of the volume before the restore. It is only kept if the rollback failed.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// restoreVolume stops the containers using the volume, runs restore and starts the containers again, whether restore succeeds or not.
+// If safetySnapshot is true, the content of the volume is copied into a snapshot volume first. The snapshot is copied back into the volume
+// if restore fails, in which case the response is a RestoreError, and it is removed otherwise.
+// On success, the response is response in JSON, or is empty if response is nil.
+func (h *Handler) restoreVolume(ctx echo.Context, cli *client.Client, volumeName string, safetySnapshot bool, successStatus int, response interface{}, restore func() error) error {
+	ctxReq := ctx.Request().Context()
+
+	// Stop container(s)
+	stoppedContainers, err := backend.StopRunningContainersAttachedToVolume(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		// The containers are started again even if the request was canceled
+		if err := backend.StartContainersByName(context.Background(), cli, stoppedContainers); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	var snapshot string
+	if safetySnapshot {
+		snapshot, err = backend.CreateSnapshot(ctxReq, cli, volumeName)
+		if err != nil {
+			return err
+		}
+		log.Infof("snapshot: %s", snapshot)
+	}
+
+	if err := restore(); err != nil {
+		if snapshot == "" {
+			var exitErr *backend.ExitError
+			if errors.As(err, &exitErr) {
+				return ctx.String(http.StatusInternalServerError, exitErr.Error())
+			}
+			return err
+		}
+
+		return h.rollback(ctx, cli, volumeName, snapshot, err)
+	}
+
+	if snapshot != "" {
+		if err := cli.VolumeRemove(ctxReq, snapshot, true); err != nil {
+			log.Error(err)
+		}
+	}
+
+	// Start container(s)
+	started = true
+	err = backend.StartContainersByName(ctxReq, cli, stoppedContainers)
+	if err != nil {
+		return err
+	}
+
+	if response != nil {
+		return ctx.JSON(successStatus, response)
+	}
+	return ctx.String(successStatus, "")
+}
+
+// rollback restores the content of the volume from the snapshot after the restore failed with restoreErr.
+func (h *Handler) rollback(ctx echo.Context, cli *client.Client, volumeName, snapshot string, restoreErr error) error {
+	log.Warnf("restoring volume %s from snapshot %s: %s", volumeName, snapshot, restoreErr)
+
+	// The rollback must complete even if the request was canceled
+	rollbackCtx := context.Background()
+
+	if err := backend.RestoreSnapshot(rollbackCtx, cli, snapshot, volumeName); err != nil {
+		log.Error(err)
+		return ctx.JSON(http.StatusInternalServerError, RestoreError{
+			Message:  restoreErr.Error(),
+			Snapshot: snapshot,
+		})
+	}
+
+	return ctx.JSON(http.StatusInternalServerError, RestoreError{
+		Message:    restoreErr.Error(),
+		RolledBack: true,
+	})
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestLoadImageWithSafetySnapshotShouldRollBack(t *testing.T) {
+	volumeID := "7f9b1d3e5a7c9e2b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3c5e7b9d2f4a6c8e0b"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	// Setup
+	// busybox has no /volume-data directory: the load fails
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("image", internal.BusyboxImage)
+	q.Set("safetySnapshot", "true")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/load")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+	// Create volume
+	_, err := cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+		Driver: "local",
+		Name:   volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate volume
+	runInVolume(t, cli, volumeID, "echo keep > /data/data.txt")
+
+	// Load image
+	err = h.LoadImage(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	var restoreErr RestoreError
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &restoreErr))
+	require.True(t, restoreErr.RolledBack)
+	require.Empty(t, restoreErr.Snapshot)
+
+	// The content of the volume is restored and the snapshot is removed
+	runInVolume(t, cli, volumeID, "grep -q keep /data/data.txt")
+
+	snapshots, err := cli.VolumeList(context.Background(), volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", backend.SnapshotLabel+"="+volumeID)),
+	})
+	require.NoError(t, err)
+	require.Empty(t, snapshots.Volumes)
+}
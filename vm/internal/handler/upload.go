@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/crypt"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// UploadTarFile imports an archive sent as the request body into a volume.
+// The body can either be the raw archive (e.g. using chunked transfer encoding) or a multipart form with the archive in the "file" field.
+// The compression of the archive (.tar, .tar.gz, .tar.zst, .tar.bz2, .tar.xz or .tar.lz4) is detected from its content.
+// The "strategy" query parameter defines what happens to the current content of the volume, as for ImportTarGzFile.
+// The archive is extracted into a staging volume before the containers are stopped, so a truncated or corrupt upload leaves the volume untouched.
+// Encrypted archives are decrypted with the passphrase sent in the X-Vackup-Passphrase header or the content of the host file "keyFile".
+func (h *Handler) UploadTarFile(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	volumeName := ctx.Param("volume")
+	strategyName := ctx.QueryParam("strategy")
+
+	if volumeName == "" {
+		return ctx.String(http.StatusBadRequest, "volume is required")
+	}
+	if err := backend.ValidateVolumeName(volumeName); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+	strategy, err := backend.ParseImportStrategy(strategyName)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	log.Infof("volumeName: %s", volumeName)
+	log.Infof("strategy: %s", strategy)
+
+	body, err := archiveFromRequest(ctx.Request())
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	// Peek at the first bytes of the archive to detect the compression without consuming the stream
+	br := bufio.NewReader(body)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	if crypt.IsEncrypted(header) {
+		secret, err := secretFromRequest(ctx, cli)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return ctx.String(http.StatusBadRequest, "archive is encrypted, a passphrase or a key file is required")
+		}
+
+		// The first chunk is decrypted here, so a wrong key fails before the volume is emptied
+		cr, err := crypt.NewReader(br, secret)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		br = bufio.NewReader(cr)
+		header, err = br.Peek(512)
+		if err != nil && err != io.EOF {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	compression, err := backend.DetectCompression(header)
+	if err != nil {
+		return ctx.String(http.StatusUnsupportedMediaType, err.Error())
+	}
+	log.Infof("compression: %s", compression.Name)
+
+	defer func() {
+		h.ProgressCache.Lock()
+		delete(h.ProgressCache.m, volumeName)
+		h.ProgressCache.Unlock()
+		_ = backend.TriggerUIRefresh(ctxReq, cli)
+	}()
+
+	// The helper containers started with the context of the request report to the progress
+	progress := backend.NewProgress("import", 0)
+	ctxReq = backend.WithProgress(ctxReq, progress)
+	ctx.SetRequest(ctx.Request().WithContext(ctxReq))
+
+	h.ProgressCache.Lock()
+	h.ProgressCache.m[volumeName] = progress
+	h.ProgressCache.Unlock()
+
+	if err := backend.TriggerUIRefresh(ctxReq, cli); err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	// The helper image might lack the decompression program
+	if err := backend.RequireProgram(ctxReq, cli, internal.AlpineTarZstdImage, compression.Program); err != nil {
+		return requireHelperCommand(ctx, err)
+	}
+
+	staging, err := backend.CreateStagingVolume(ctxReq, cli, volumeName)
+	if err != nil {
+		return err
+	}
+	log.Infof("staging: %s", staging)
+	defer func() {
+		if err := cli.VolumeRemove(context.Background(), staging, true); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	// The staging volume is empty, so there is nothing to remove or to skip
+	err = extractIntoVolume(ctxReq, cli, staging, br, compression.Program, nil, backend.ImportStrategyMerge)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "archive could not be extracted: "+strings.TrimSpace(exitErr.Error()))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The containers are stopped only once the whole body was extracted, so a truncated upload leaves the volume untouched
+	return h.restoreVolume(ctx, cli, volumeName, false, http.StatusOK, nil, func() error {
+		// The progress reports the extraction of the archive, not the copy of the staging volume
+		return backend.CopyVolume(backend.WithProgress(ctxReq, nil), cli, staging, volumeName, strategy)
+	})
+}
+
+// extractIntoVolume extracts the archive read from r into the volume, after removing its content if the strategy is ImportStrategyReplace.
+// decompressProgram is the program tar uses to decompress the archive, or an empty string if the archive is not compressed.
+// If entries are given, only these entries are extracted from an archive exported by the extension, and the rest of the volume is left untouched.
+func extractIntoVolume(ctx context.Context, cli *client.Client, volumeName string, r io.Reader, decompressProgram string, entries []string, strategy backend.ImportStrategy) error {
+	rmCmd := strategy.RemoveCmd("/vackup-volume")
+
+	// tar reads the archive from stdin when "-f -" is used
+	tarCmd := []string{"tar"}
+	if decompressProgram != "" {
+		tarCmd = append(tarCmd, "-I", decompressProgram)
+	}
+	tarCmd = append(tarCmd, strategy.TarOpts()...)
+	tarCmd = append(tarCmd, backend.ProgressTarOpts, "-xf", "-", "-C", "/vackup-volume")
+
+	script := rmCmd + strings.Join(tarCmd, " ")
+	if len(entries) > 0 {
+		// The entries are passed to the script as positional parameters
+		script = prefixArgsCmd("./") + "; " + strings.Join(tarCmd, " ") + ` -- "$@"`
+	}
+	log.Infof("script: %s", script)
+
+	return backend.StreamIntoContainer(ctx, cli, &container.Config{
+		Image: internal.AlpineTarZstdImage,
+		Cmd:   backend.ShellCmd(script, entryMembers(entries)...),
+		Labels: map[string]string{
+			"com.docker.desktop.extension":        "true",
+			"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+			"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+			"com.volumes-backup-extension.action": "import",
+			"com.volumes-backup-extension.volume": volumeName,
+		},
+	}, &container.HostConfig{
+		Binds: []string{
+			volumeName + ":" + "/vackup-volume",
+		},
+	}, r)
+}
+
+// archiveFromRequest returns a reader for the archive sent in the request body.
+// For multipart requests, it returns the content of the "file" field without buffering it to disk.
+func archiveFromRequest(req *http.Request) (io.Reader, error) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	if err != nil || mediaType != echo.MIMEMultipartForm {
+		return req.Body, nil
+	}
+
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("multipart form does not contain a %q field", "file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestUploadTarFile(t *testing.T) {
+	volumeID := "0e2b3b0c5a0b3c9f5a1a2f8b8e0c6c1ef77d6ad4e9b5d2a8e1f3c7b6a5d4e3f2"
+	cli := setupDockerClient(t)
+
+	defer func() {
+		_ = cli.VolumeRemove(context.Background(), volumeID, true)
+	}()
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.ReadFile(filepath.Join(pwd, "testdata", "import", "nginx.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	multipartBody := new(bytes.Buffer)
+	mw := multipart.NewWriter(multipartBody)
+	fw, err := mw.CreateFormFile("file", "nginx.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := map[string]struct {
+		contentType string
+		body        io.Reader
+	}{
+		"raw":       {contentType: "application/gzip", body: bytes.NewReader(archive)},
+		"multipart": {contentType: mw.FormDataContentType(), body: multipartBody},
+	}
+
+	for name, tc := range bodies {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", tc.body)
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/volumes/:volume/import")
+			c.SetParamNames("volume")
+			c.SetParamValues(volumeID)
+			h := New(c.Request().Context(), func() (*client.Client, error) { return setupDockerClient(t), nil })
+
+			// Create volume
+			_, err = cli.VolumeCreate(c.Request().Context(), volume.CreateOptions{
+				Driver: "local",
+				Name:   volumeID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Upload archive into volume
+			err = h.UploadTarFile(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			sizes, err := backend.GetVolumesSize(c.Request().Context(), cli, volumeID)
+			require.NoError(t, err)
+			require.Equal(t, int64(16000), sizes[volumeID].Bytes)
+			require.Equal(t, "16.0 kB", sizes[volumeID].Human)
+		})
+	}
+}
+
+func TestUploadTarFileWithUnknownFormatShouldFail(t *testing.T) {
+	volumeID := "6f4c1b2a3d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a"
+
+	// Setup
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("this is not an archive")))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/volumes/:volume/import")
+	c.SetParamNames("volume")
+	c.SetParamValues(volumeID)
+	h := &Handler{
+		DockerClient:  func() (*client.Client, error) { return setupDockerClient(t), nil },
+		ProgressCache: &ProgressCache{m: make(map[string]*backend.Progress)},
+	}
+
+	// Upload archive into volume
+	err := h.UploadTarFile(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
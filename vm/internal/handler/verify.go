@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/labstack/echo/v4"
+
+	"github.com/docker/volumes-backup-extension/internal"
+	"github.com/docker/volumes-backup-extension/internal/backend"
+	"github.com/docker/volumes-backup-extension/internal/log"
+)
+
+// VerifyArchive checks the archive located in the host at "path" against the manifest written next to it when it was exported.
+// Every file of the archive is read and its checksum computed, but the archive is never extracted into a volume.
+func (h *Handler) VerifyArchive(ctx echo.Context) error {
+	ctxReq := ctx.Request().Context()
+	path := ctx.QueryParam("path")
+
+	if path == "" {
+		return ctx.String(http.StatusBadRequest, "path is required")
+	}
+	if err := backend.ValidateHostPath(path); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	log.Infof("path: %s", path)
+
+	cli, err := h.DockerClient()
+	if err != nil {
+		return err
+	}
+
+	// Ensure the image is present before creating the container
+	reader, err := cli.ImagePull(ctxReq, internal.AlpineTarZstdImage, types.ImagePullOptions{
+		Platform: "linux/" + runtime.GOARCH,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{
+		"com.docker.desktop.extension":        "true",
+		"com.docker.desktop.extension.name":   "Volumes Backup & Share",
+		"com.docker.compose.project":          "docker_volumes-backup-extension-desktop-extension",
+		"com.volumes-backup-extension.action": "verify",
+		"com.volumes-backup-extension.path":   path,
+	}
+	hostConfig := &container.HostConfig{
+		// Use mounts instead of binds so that the creation of the container fails if the archive or its manifest
+		// don't exist, instead of creating empty directories in their place.
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: path, Target: "/vackup", ReadOnly: true},
+			{Type: mount.TypeBind, Source: path + backend.ManifestExt, Target: "/vackup-manifest", ReadOnly: true},
+		},
+	}
+
+	// Read the manifest file, then compute the manifest from the content of the archive
+	var expectedOut bytes.Buffer
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image:  internal.AlpineTarZstdImage,
+		Cmd:    []string{"cat", "/vackup-manifest"},
+		Labels: labels,
+	}, hostConfig, &expectedOut)
+	var exitErr *backend.ExitError
+	if errors.As(err, &exitErr) {
+		return ctx.String(http.StatusUnprocessableEntity, "manifest could not be read")
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "bind source path does not exist") {
+			return ctx.String(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	cmd := []string{"tar", "-xf", "/vackup", "-C", "/tmp", "--to-command=" + backend.ManifestCmd}
+	log.Infof("cmd: %v", cmd)
+
+	var actualOut bytes.Buffer
+	err = backend.StreamFromContainer(ctxReq, cli, &container.Config{
+		Image:  internal.AlpineTarZstdImage,
+		Cmd:    cmd,
+		Labels: labels,
+	}, hostConfig, &actualOut)
+	exitErr = nil
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+
+	expected, err := backend.ParseManifest(&expectedOut)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	actual, err := backend.ParseManifest(&actualOut)
+	if err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	report := backend.CompareManifests(expected, actual)
+	if exitErr != nil {
+		// tar could not read the archive until the end, e.g. the archive is truncated or corrupt
+		report.Valid = false
+		report.Error = "archive could not be read: " + strings.TrimSpace(exitErr.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/volumes-backup-extension/internal/backend"
+)
+
+func TestVerifyArchive(t *testing.T) {
+	cli := setupDockerClient(t)
+
+	volume := "f1c9e2d8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0"
+	setupVolume(context.Background(), cli, volume, "docker.io/library/nginx:1.21", "/usr/share/nginx/html:ro")
+
+	tmpDir, err := os.MkdirTemp("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+		_ = cli.VolumeRemove(context.Background(), volume, true)
+	}()
+
+	rec := export(cli, volume, tmpDir, ".tar.gz")
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	archive := filepath.Join(tmpDir, volume+".tar.gz")
+	require.FileExists(t, archive+backend.ManifestExt)
+
+	// The archive matches its manifest
+	rec = verify(t, cli, archive)
+	require.Equal(t, http.StatusOK, rec.Code)
+	report := backend.VerifyReport{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.True(t, report.Valid)
+	require.Equal(t, 2, report.Files)
+
+	// Truncate the archive to simulate a partial copy
+	fi, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(archive, fi.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = verify(t, cli, archive)
+	require.Equal(t, http.StatusOK, rec.Code)
+	report = backend.VerifyReport{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.False(t, report.Valid)
+	require.NotEmpty(t, report.Error)
+
+	// The archive does not exist
+	rec = verify(t, cli, filepath.Join(tmpDir, "does-not-exist.tar.gz"))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func verify(t *testing.T, cli *client.Client, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	q := make(url.Values)
+	q.Set("path", path)
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/archives/verify")
+	h := New(c.Request().Context(), func() (*client.Client, error) { return cli, nil })
+
+	err := h.VerifyArchive(c)
+	require.NoError(t, err)
+
+	return rec
+}
@@ -0,0 +1,12 @@
+package internal
+
+import "os"
+
+// Version returns the version of the extension, i.e. the tag of its image, which is set in the BUGSNAG_APP_VERSION environment variable
+// of the backend when the image is built.
+func Version() string {
+	if v := os.Getenv("BUGSNAG_APP_VERSION"); v != "" {
+		return v
+	}
+	return "latest"
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 
+	"github.com/docker/volumes-backup-extension/internal/backend"
 	"github.com/docker/volumes-backup-extension/internal/handler"
 	"github.com/docker/volumes-backup-extension/internal/log"
 	"github.com/docker/volumes-backup-extension/internal/setup"
@@ -25,8 +26,9 @@ var (
 )
 
 func main() {
-	var socketPath string
+	var socketPath, catalogPath string
 	flag.StringVar(&socketPath, "socket", "/run/guest/ext.sock", "Unix domain socket to listen on")
+	flag.StringVar(&catalogPath, "catalog", "/catalog/backups.json", "JSON file of the backups tracked by the catalog")
 	flag.Parse()
 
 	setup.ConfigureBugsnag()
@@ -71,8 +73,10 @@ func main() {
 	}
 
 	h = handler.New(context.Background(), cliFactory)
+	h.Catalog = backend.NewCatalog(catalogPath)
 
 	router.GET("/progress", h.ActionsInProgress)
+	router.GET("/progress/stream", h.StreamProgress)
 	router.GET("/volumes", h.Volumes)
 	router.GET("/volumes/size", h.VolumesSize)
 	router.GET("/volumes/container", h.VolumesContainer)
@@ -81,10 +85,19 @@ func main() {
 	router.POST("/volumes/:volume/delete", h.DeleteVolume)
 	router.GET("/volumes/:volume/export", h.ExportVolume)
 	router.GET("/volumes/:volume/import", h.ImportTarGzFile)
+	router.POST("/volumes/:volume/import", h.UploadTarFile)
 	router.GET("/volumes/:volume/save", h.SaveVolume)
 	router.GET("/volumes/:volume/load", h.LoadImage)
+	router.GET("/volumes/:volume/export-oci", h.ExportOCI)
+	router.GET("/volumes/:volume/import-oci", h.ImportOCI)
 	router.POST("/volumes/:volume/push", h.PushVolume)
 	router.POST("/volumes/:volume/pull", h.PullVolume)
+	router.GET("/volumes/:volume/backups", h.VolumeBackups)
+	router.GET("/backups", h.Backups)
+	router.POST("/backups/:id/delete", h.DeleteBackup)
+	router.GET("/archives/verify", h.VerifyArchive)
+	router.GET("/bundles/export", h.ExportBundle)
+	router.GET("/bundles/import", h.ImportBundle)
 
 	// Start server
 	go func() {